@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+
+	"go.uber.org/zap"
+)
+
+const localAdminListen = "tcp/localhost:2019"
+const localAdminAddress = "localhost:2019"
+
+// Maximum size of requests to the admin proxy, their body is read before they are authenticated
+const maxAdminRequestSize = 64 << 20
+
+// AdminProxy exposes the local caddy admin endpoint to controllers,
+// only forwarding requests that are authenticated
+type AdminProxy struct {
+	options  *config.Options
+	listen   string
+	adminTLS *AdminTLS
+	proxy    *httputil.ReverseProxy
+}
+
+// adminIsProxied returns if servers keep caddy admin local, behind an admin proxy
+func adminIsProxied(options *config.Options) bool {
+	return options.Secret != "" || options.AdminTLSCA != ""
+}
+
+// CreateAdminProxy creates an admin proxy listening on the given address
+func CreateAdminProxy(options *config.Options, listen string, adminTLS *AdminTLS) *AdminProxy {
+	target := &url.URL{Scheme: "http", Host: localAdminAddress}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		// Caddy admin endpoint only accepts requests to the address it listens on
+		req.Host = target.Host
+	}
+
+	return &AdminProxy{
+		options:  options,
+		listen:   listen,
+		adminTLS: adminTLS,
+		proxy:    proxy,
+	}
+}
+
+// Start listening for controller requests
+func (adminProxy *AdminProxy) Start() error {
+	listener, err := net.Listen("tcp", adminProxy.listen)
+	if err != nil {
+		return err
+	}
+
+	if adminProxy.adminTLS != nil {
+		listener = tls.NewListener(listener, adminProxy.adminTLS.ServerConfig())
+	}
+
+	logger().Info("Admin proxy listening", zap.String("address", adminProxy.listen), zap.Bool("tls", adminProxy.adminTLS != nil))
+
+	go func() {
+		err := http.Serve(listener, adminProxy)
+		logger().Error("Admin proxy stopped", zap.Error(err))
+	}()
+
+	return nil
+}
+
+func (adminProxy *AdminProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := logger()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminRequestSize))
+	if err != nil {
+		log.Error("Failed to read admin request", zap.String("remote", r.RemoteAddr), zap.Error(err))
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	if adminProxy.options.Secret != "" {
+		if err := verifyRequest(r, body, adminProxy.options.Secret); err != nil {
+			log.Warn("Rejected admin request", zap.String("remote", r.RemoteAddr), zap.String("uri", r.RequestURI), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	adminProxy.proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+)
+
+const issuedCertificateValidity = 365 * 24 * time.Hour
+
+// Organizational unit identifying controller certificates, servers only accept clients presenting it
+const controllerCertificateOU = "caddy-docker-proxy controller"
+
+// AdminTLS holds certificates used for mutual TLS between controllers and servers
+type AdminTLS struct {
+	caPool      *x509.CertPool
+	certificate tls.Certificate
+}
+
+// LoadAdminTLS loads admin TLS certificates from options, returning nil when TLS is disabled.
+// When no certificate is configured, one is issued for mode using the CA key.
+func LoadAdminTLS(options *config.Options, mode config.Mode) (*AdminTLS, error) {
+	if options.AdminTLSCA == "" {
+		return nil, nil
+	}
+
+	caPEM, err := readFileOrSecret(options.AdminTLSCA)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read admin TLS CA: %w", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("No certificates found in admin TLS CA")
+	}
+
+	var certificate tls.Certificate
+	if options.AdminTLSCert != "" {
+		certPEM, err := readFileOrSecret(options.AdminTLSCert)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read admin TLS certificate: %w", err)
+		}
+		keyPEM, err := readFileOrSecret(options.AdminTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read admin TLS key: %w", err)
+		}
+		certificate, err = tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load admin TLS certificate: %w", err)
+		}
+	} else if options.AdminTLSCAKey != "" {
+		caKeyPEM, err := readFileOrSecret(options.AdminTLSCAKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read admin TLS CA key: %w", err)
+		}
+		certificate, err = issueCertificate(caPEM, caKeyPEM, mode)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to issue admin TLS certificate: %w", err)
+		}
+	} else {
+		return nil, errors.New("Admin TLS requires a certificate or a CA key to issue one")
+	}
+
+	return &AdminTLS{
+		caPool:      caPool,
+		certificate: certificate,
+	}, nil
+}
+
+// ServerConfig returns the TLS config for servers, requiring controller client certificates signed by the CA.
+// Certificates of other servers are signed by the same CA, but are not accepted.
+func (adminTLS *AdminTLS) ServerConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{adminTLS.certificate},
+		ClientCAs:    adminTLS.caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		VerifyPeerCertificate: func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				if len(chain) > 0 && isControllerCertificate(chain[0]) {
+					return nil
+				}
+			}
+			return errors.New("Client certificate is not a controller certificate")
+		},
+	}
+}
+
+func isControllerCertificate(cert *x509.Certificate) bool {
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == controllerCertificateOU {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientConfig returns the TLS config for controllers.
+// Servers are addressed by container IPs, so only the certificate chain is verified, not the host name.
+func (adminTLS *AdminTLS) ClientConfig() *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{adminTLS.certificate},
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("Server didn't present a certificate")
+			}
+			intermediates := x509.NewCertPool()
+			var leaf *x509.Certificate
+			for i, rawCert := range rawCerts {
+				cert, err := x509.ParseCertificate(rawCert)
+				if err != nil {
+					return err
+				}
+				if i == 0 {
+					leaf = cert
+				} else {
+					intermediates.AddCert(cert)
+				}
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{
+				Roots:         adminTLS.caPool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			return err
+		},
+	}
+}
+
+// issueCertificate issues a certificate for the roles of mode: servers authenticate as TLS servers,
+// and controllers as TLS clients identified by the controller organizational unit
+func issueCertificate(caPEM []byte, caKeyPEM []byte, mode config.Mode) (tls.Certificate, error) {
+	caPair, err := tls.X509KeyPair(caPEM, caKeyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	ca, err := x509.ParseCertificate(caPair.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	caKey, isSigner := caPair.PrivateKey.(crypto.Signer)
+	if !isSigner {
+		return tls.Certificate{}, errors.New("Unsupported CA key type")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	hostname, _ := os.Hostname()
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(issuedCertificateValidity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: "caddy-docker-proxy " + hostname},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{},
+	}
+	if mode&config.Server == config.Server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if mode&config.Controller == config.Controller {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		template.Subject.OrganizationalUnit = []string{controllerCertificateOU}
+	}
+	if hostname != "" {
+		template.DNSNames = []string{hostname}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der, caPair.Certificate[0]},
+		PrivateKey:  key,
+	}, nil
+}
+
+// readFileOrSecret reads a file path, falling back to a docker secret with that name
+func readFileOrSecret(value string) ([]byte, error) {
+	data, err := ioutil.ReadFile(value)
+	if os.IsNotExist(err) && !strings.ContainsAny(value, `/\`) {
+		return ioutil.ReadFile(filepath.Join(dockerSecretsDir(), value))
+	}
+	return data, err
+}
+
+func dockerSecretsDir() string {
+	if runtime.GOOS == "windows" {
+		return `C:\ProgramData\Docker\secrets`
+	}
+	return "/run/secrets"
+}
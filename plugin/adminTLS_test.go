@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestCA(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func loadTestAdminTLS(t *testing.T, caPEM []byte, caKeyPEM []byte, mode config.Mode) *AdminTLS {
+	dir := t.TempDir()
+	caPath, caKeyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	assert.NoError(t, ioutil.WriteFile(caPath, caPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(caKeyPath, caKeyPEM, 0600))
+	adminTLS, err := LoadAdminTLS(&config.Options{AdminTLSCA: caPath, AdminTLSCAKey: caKeyPath}, mode)
+	assert.NoError(t, err)
+	return adminTLS
+}
+
+func handshake(t *testing.T, clientConfig *tls.Config, serverConfig *tls.Config) error {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err == nil {
+		conn.Close()
+	}
+	// With TLS 1.3, servers verify client certificates after clients complete the handshake
+	if serverErr := <-serverErr; serverErr != nil {
+		return serverErr
+	}
+	return err
+}
+
+func TestAdminTLS_ControllerCertificates(t *testing.T) {
+	caPEM, caKeyPEM := createTestCA(t)
+	server := loadTestAdminTLS(t, caPEM, caKeyPEM, config.Server)
+	controller := loadTestAdminTLS(t, caPEM, caKeyPEM, config.Controller)
+
+	assert.NoError(t, handshake(t, controller.ClientConfig(), server.ServerConfig()))
+
+	// Servers can't configure other servers with their own certificate
+	otherServer := loadTestAdminTLS(t, caPEM, caKeyPEM, config.Server)
+	assert.Error(t, handshake(t, otherServer.ClientConfig(), server.ServerConfig()))
+
+	// Client certificates signed by the CA must also identify controllers
+	caPair, err := tls.X509KeyPair(caPEM, caKeyPEM)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caPair.Certificate[0])
+	assert.NoError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, key.Public(), caPair.PrivateKey)
+	assert.NoError(t, err)
+	client := &AdminTLS{
+		caPool:      controller.caPool,
+		certificate: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+	err = handshake(t, client.ClientConfig(), server.ServerConfig())
+	assert.Error(t, err)
+}
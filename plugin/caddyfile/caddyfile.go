@@ -24,8 +24,17 @@ import (
 // }
 type Block struct {
 	*Container
-	Order int
-	Keys  []string
+	Order      int
+	Keys       []string
+	Provenance []*Provenance
+}
+
+// Provenance identifies the resource, and optionally the label, a block was generated from
+type Provenance struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Label string `json:"label,omitempty"`
 }
 
 // Container represents a collection of blocks
@@ -49,11 +58,84 @@ func CreateContainer() *Container {
 	}
 }
 
+// Clone creates a deep copy of container
+func (container *Container) Clone() *Container {
+	clone := CreateContainer()
+	for _, block := range container.Children {
+		clone.AddBlock(block.Clone())
+	}
+	return clone
+}
+
+// Clone creates a deep copy of block
+func (block *Block) Clone() *Block {
+	clone := &Block{
+		Container: block.Container.Clone(),
+		Order:     block.Order,
+		Keys:      append([]string{}, block.Keys...),
+	}
+	for _, provenance := range block.Provenance {
+		provenanceCopy := *provenance
+		clone.AddProvenance(&provenanceCopy)
+	}
+	return clone
+}
+
 // AddKeys to block
 func (block *Block) AddKeys(keys ...string) {
 	block.Keys = append(block.Keys, keys...)
 }
 
+// AddProvenance to block
+func (block *Block) AddProvenance(provenance ...*Provenance) {
+	block.Provenance = append(block.Provenance, provenance...)
+}
+
+// SetProvenance sets the resource all blocks in this container were generated from, keeping their labels.
+// Top level blocks without provenance, like blocks parsed from a caddyfile, get one.
+func (container *Container) SetProvenance(kind string, id string, name string) {
+	for _, block := range container.Children {
+		if len(block.Provenance) == 0 {
+			block.AddProvenance(&Provenance{})
+		}
+		block.setProvenance(kind, id, name)
+	}
+}
+
+func (block *Block) setProvenance(kind string, id string, name string) {
+	for _, provenance := range block.Provenance {
+		provenance.Kind = kind
+		provenance.ID = id
+		provenance.Name = name
+	}
+	for _, child := range block.Children {
+		child.setProvenance(kind, id, name)
+	}
+}
+
+// GetAllProvenance gets the provenance of a block and all its descendants
+func (block *Block) GetAllProvenance() []*Provenance {
+	all := append([]*Provenance{}, block.Provenance...)
+	for _, child := range block.Children {
+		all = append(all, child.GetAllProvenance()...)
+	}
+	return all
+}
+
+// String describes a provenance, like: container whoami label caddy.reverse_proxy
+func (provenance *Provenance) String() string {
+	description := provenance.Kind
+	if provenance.Name != "" {
+		description += " " + provenance.Name
+	} else if provenance.ID != "" {
+		description += " " + provenance.ID
+	}
+	if provenance.Label != "" {
+		description += " label " + provenance.Label
+	}
+	return description
+}
+
 // AddBlock to container
 func (container *Container) AddBlock(block *Block) {
 	container.Children = append(container.Children, block)
@@ -2,6 +2,7 @@ package caddyfile
 
 import (
 	"bytes"
+	"fmt"
 	"math"
 	"regexp"
 	"strconv"
@@ -11,6 +12,21 @@ import (
 var whitespaceRegex = regexp.MustCompile("\\s+")
 var labelParserRegex = regexp.MustCompile(`^(?:(.+)\.)?(?:(\d+)_)?([^.]+?)(?:_(\d+))?$`)
 
+// LabelError is an error caused by the value of a label
+type LabelError struct {
+	Label string
+	Err   error
+}
+
+func (e *LabelError) Error() string {
+	return fmt.Sprintf("label %s: %s", e.Label, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *LabelError) Unwrap() error {
+	return e.Err
+}
+
 // FromLabels converts key value labels into a caddyfile
 func FromLabels(labels map[string]string, templateData interface{}, templateFuncs template.FuncMap) (*Container, error) {
 	container := CreateContainer()
@@ -20,11 +36,11 @@ func FromLabels(labels map[string]string, templateData interface{}, templateFunc
 		block := getOrCreateBlock(container, label, blocksByPath)
 		argsText, err := processVariables(templateData, templateFuncs, value)
 		if err != nil {
-			return nil, err
+			return nil, &LabelError{Label: label, Err: err}
 		}
 		args, err := parseArgs(argsText)
 		if err != nil {
-			return nil, err
+			return nil, &LabelError{Label: label, Err: err}
 		}
 		block.AddKeys(args...)
 	}
@@ -41,6 +57,7 @@ func getOrCreateBlock(container *Container, path string, blocksByPath map[string
 
 	block := CreateBlock()
 	block.Order = order
+	block.AddProvenance(&Provenance{Label: path})
 
 	if parentPath != "" {
 		parentBlock := getOrCreateBlock(container, parentPath, blocksByPath)
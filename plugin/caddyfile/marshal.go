@@ -13,7 +13,15 @@ import (
 func (container *Container) Marshal() []byte {
 	container.sort()
 	buffer := &bytes.Buffer{}
-	container.write(buffer, 0)
+	container.write(buffer, 0, false)
+	return buffer.Bytes()
+}
+
+// MarshalWithProvenance marshals container into caddyfile bytes, with comments describing where each block came from
+func (container *Container) MarshalWithProvenance() []byte {
+	container.sort()
+	buffer := &bytes.Buffer{}
+	container.write(buffer, 0, true)
 	return buffer.Bytes()
 }
 
@@ -21,19 +29,32 @@ func (container *Container) Marshal() []byte {
 func (block *Block) Marshal() []byte {
 	block.Container.sort()
 	buffer := &bytes.Buffer{}
-	block.write(buffer, 0)
+	block.write(buffer, 0, false)
+	return buffer.Bytes()
+}
+
+// MarshalWithProvenance marshals block into caddyfile bytes, with comments describing where each block came from
+func (block *Block) MarshalWithProvenance() []byte {
+	block.Container.sort()
+	buffer := &bytes.Buffer{}
+	block.write(buffer, 0, true)
 	return buffer.Bytes()
 }
 
 // write all blocks to a buffer
-func (container *Container) write(buffer *bytes.Buffer, level int) {
+func (container *Container) write(buffer *bytes.Buffer, level int, withProvenance bool) {
 	for _, block := range container.Children {
-		block.write(buffer, level)
+		block.write(buffer, level, withProvenance)
 	}
 }
 
 // write block to a buffer
-func (block *Block) write(buffer *bytes.Buffer, level int) {
+func (block *Block) write(buffer *bytes.Buffer, level int, withProvenance bool) {
+	if withProvenance {
+		for _, provenance := range block.Provenance {
+			buffer.WriteString(strings.Repeat("\t", level) + "# " + provenance.String() + "\n")
+		}
+	}
 	buffer.WriteString(strings.Repeat("\t", level))
 	needsWhitespace := false
 	for _, key := range block.Keys {
@@ -62,7 +83,7 @@ func (block *Block) write(buffer *bytes.Buffer, level int) {
 			buffer.WriteString(" ")
 		}
 		buffer.WriteString("{\n")
-		block.Container.write(buffer, level+1)
+		block.Container.write(buffer, level+1, withProvenance)
 		buffer.WriteString(strings.Repeat("\t", level) + "}")
 	}
 	buffer.WriteString("\n")
@@ -10,9 +10,11 @@ OuterLoop:
 		for _, blockA := range containerA.GetAllByFirstKey(firstKey) {
 			if (firstKey == "reverse_proxy" || firstKey == "php_fastcgi") && getMatcher(blockA) == getMatcher(blockB) {
 				mergeReverseProxyLike(blockA, blockB)
+				blockA.AddProvenance(blockB.Provenance...)
 				continue OuterLoop
 			} else if blocksAreEqual(blockA, blockB) {
 				blockA.Container.Merge(blockB.Container)
+				blockA.AddProvenance(blockB.Provenance...)
 				continue OuterLoop
 			}
 		}
@@ -22,13 +24,23 @@ OuterLoop:
 
 func mergeReverseProxyLike(blockA *Block, blockB *Block) {
 	for index, key := range blockB.Keys[1:] {
-		if index > 0 || !isMatcher(key) {
+		// Replicas resolving the same upstreams are merged only once
+		if (index > 0 || !isMatcher(key)) && !containsKey(blockA.Keys[1:], key) {
 			blockA.AddKeys(key)
 		}
 	}
 	blockA.Container.Merge(blockB.Container)
 }
 
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
 func getMatcher(block *Block) string {
 	if len(block.Keys) <= 1 || !isMatcher(block.Keys[1]) {
 		return "*"
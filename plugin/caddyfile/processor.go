@@ -7,21 +7,54 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig"
 )
 
+// ProcessResult is the result of processing a caddyfile
+type ProcessResult struct {
+	Container     *Container
+	Caddyfile     []byte
+	Logs          []byte
+	RemovedBlocks []*RemovedBlock
+}
+
+// RemovedBlock is a block removed from caddyfile because caddy failed to adapt it.
+// Block is nil when the whole caddyfile couldn't be parsed.
+type RemovedBlock struct {
+	Block *Block
+	Err   error
+}
+
 // Process caddyfile and removes wrong server blocks
 func Process(caddyfileContent []byte) ([]byte, []byte) {
+	result := ProcessContent(caddyfileContent)
+	return result.Caddyfile, result.Logs
+}
+
+// ProcessContent processes caddyfile like Process, also returning removed blocks
+func ProcessContent(caddyfileContent []byte) *ProcessResult {
 	if len(caddyfileContent) == 0 {
-		return caddyfileContent, nil
+		return &ProcessResult{Container: CreateContainer(), Caddyfile: caddyfileContent}
 	}
 
-	logsBuffer := bytes.Buffer{}
-	adapter := caddyconfig.GetAdapter("caddyfile")
-
 	container, err := Unmarshal(caddyfileContent)
 	if err != nil {
-		logsBuffer.WriteString(fmt.Sprintf("[ERROR]  Invalid caddyfile: %s\n%s\n", err.Error(), caddyfileContent))
-		return nil, logsBuffer.Bytes()
+		logs := fmt.Sprintf("[ERROR]  Invalid caddyfile: %s\n%s\n", err.Error(), caddyfileContent)
+		return &ProcessResult{
+			Container:     CreateContainer(),
+			Logs:          []byte(logs),
+			RemovedBlocks: []*RemovedBlock{{Err: err}},
+		}
 	}
 
+	return container.Process()
+}
+
+// Process container and removes wrong server blocks.
+// Logs of removed blocks include comments describing where they were generated from.
+func (container *Container) Process() *ProcessResult {
+	logsBuffer := bytes.Buffer{}
+	adapter := caddyconfig.GetAdapter("caddyfile")
+
+	removedBlocks := []*RemovedBlock{}
+
 	newContainer := CreateContainer()
 
 	container.sort()
@@ -32,9 +65,15 @@ func Process(caddyfileContent []byte) ([]byte, []byte) {
 
 		if err != nil {
 			newContainer.Remove(block)
-			logsBuffer.WriteString(fmt.Sprintf("[ERROR]  Removing invalid block: %s\n%s\n", err.Error(), block.Marshal()))
+			removedBlocks = append(removedBlocks, &RemovedBlock{Block: block, Err: err})
+			logsBuffer.WriteString(fmt.Sprintf("[ERROR]  Removing invalid block: %s\n%s\n", err.Error(), block.MarshalWithProvenance()))
 		}
 	}
 
-	return newContainer.Marshal(), logsBuffer.Bytes()
+	return &ProcessResult{
+		Container:     newContainer,
+		Caddyfile:     newContainer.Marshal(),
+		Logs:          logsBuffer.Bytes(),
+		RemovedBlocks: removedBlocks,
+	}
 }
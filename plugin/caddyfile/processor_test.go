@@ -64,3 +64,22 @@ func TestProcessCaddyfile(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessContent(t *testing.T) {
+	result := ProcessContent([]byte("service1.example.com {\n\treverse_proxy service1:5000 {\n\t\tinvalid\n\t}\n}\nservice2.example.com {\n\treverse_proxy service2:5000\n}\n"))
+
+	assert.Equal(t, "service2.example.com {\n\treverse_proxy service2:5000\n}\n", string(result.Caddyfile))
+	assert.Equal(t, string(result.Caddyfile), string(result.Container.Marshal()))
+	assert.Len(t, result.RemovedBlocks, 1)
+	assert.Equal(t, []string{"service1.example.com"}, result.RemovedBlocks[0].Block.Keys)
+	assert.Error(t, result.RemovedBlocks[0].Err)
+}
+
+func TestProcessContent_InvalidFile(t *testing.T) {
+	result := ProcessContent([]byte("service1.example.com {\n}\n}\n"))
+
+	assert.Empty(t, result.Caddyfile)
+	assert.Len(t, result.RemovedBlocks, 1)
+	assert.Nil(t, result.RemovedBlocks[0].Block)
+	assert.Error(t, result.RemovedBlocks[0].Err)
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,8 +23,19 @@ func init() {
 	caddycmd.RegisterCommand(caddycmd.Command{
 		Name:  "docker-proxy",
 		Func:  cmdFunc,
-		Usage: "<command>",
+		Usage: "[render | lint <files>]",
 		Short: "Run caddy as a docker proxy",
+		Long: `
+Runs caddy as a docker proxy, generating its configuration from docker labels.
+
+With the render subcommand, connects to docker, prints the generated Caddyfile
+and JSON config, and exits. The exit code is not zero when invalid blocks were
+removed or when the Caddyfile can't be adapted, which is useful to validate
+labels in CI before rolling out changes.
+
+With the lint subcommand, validates labels from docker-compose files or docker
+inspect JSON outputs, without connecting to docker. Upstreams resolve to a stub
+IP, and errors are reported with the label that caused them.`,
 		Flags: func() *flag.FlagSet {
 			fs := flag.NewFlagSet("docker-proxy", flag.ExitOnError)
 
@@ -31,15 +43,22 @@ func init() {
 				"Which mode this instance should run: standalone | controller | server")
 
 			fs.String("controller-network", "",
-				"Network allowed to configure caddy server in CIDR notation. Ex: 10.200.200.0/24")
+				"Comma separated networks allowed to configure caddy server in CIDR notation. Ex: 10.200.200.0/24,fd00:200::/64")
 
 			fs.String("ingress-networks", "",
 				"Comma separated name of ingress networks connecting caddy servers to containers.\n"+
 					"When not defined, networks attached to controller container are considered ingress networks")
 
+			fs.String("ip-version", "ipv4",
+				"Which IP addresses of containers and tasks are used: ipv4 | ipv6 | both.\n"+
+					"ipv4 and ipv6 fall back to the other version on networks without addresses of the preferred one")
+
 			fs.String("caddyfile-path", "",
 				"Path to a base Caddyfile that will be extended with docker sites")
 
+			fs.String("static-containers-path", "",
+				"Path to a JSON or YAML file of static containers, with labels and upstreams, to proxy backends outside docker")
+
 			fs.String("label-prefix", generator.DefaultLabelPrefix,
 				"Prefix for Docker labels")
 
@@ -49,15 +68,68 @@ func init() {
 			fs.Bool("process-caddyfile", true,
 				"Process Caddyfile before loading it, removing invalid servers")
 
+			fs.Bool("provenance-comments", false,
+				"Add comments to the generated Caddyfile describing which container, service or config each block came from")
+
 			fs.Duration("polling-interval", 30*time.Second,
 				"Interval caddy should manually check docker for a new caddyfile")
 
+			fs.Duration("drain-timeout", 0,
+				"Maximum time a stopping container is kept in upstreams, waiting for its in-flight requests. Disabled when zero")
+
+			fs.String("leader-lease-path", "",
+				"Path of a lease file shared by controller replicas, electing the one pushing configurations to servers.\n"+
+					"Leader election is disabled when not defined")
+
+			fs.Duration("leader-lease-duration", defaultLeaderLeaseDuration,
+				"Time a leader keeps its lease without renewing it, before another controller replica takes over")
+
+			fs.String("secret", "",
+				"Secret shared by controller and servers to sign configuration pushes")
+
+			fs.String("status-listen", "",
+				"Address where the controller serves its status API. Ex: :8080")
+
+			fs.Int("config-history", 10,
+				"Number of generated configurations the controller keeps, to stop pushing the ones rejected by most servers and to roll back")
+
+			fs.String("rejection-webhook", "",
+				"URL notified with a JSON POST when labels of a container, service or config generate an invalid block")
+
+			fs.String("admin-tls-ca", "",
+				"Path or docker secret name of the CA certificate used for mutual TLS between controller and servers")
+
+			fs.String("admin-tls-ca-key", "",
+				"Path or docker secret name of the CA key, used to issue a certificate when admin-tls-cert is not defined")
+
+			fs.String("admin-tls-cert", "",
+				"Path or docker secret name of the certificate used for mutual TLS between controller and servers")
+
+			fs.String("admin-tls-key", "",
+				"Path or docker secret name of the key of admin-tls-cert")
+
 			return fs
 		}(),
 	})
 }
 
 func cmdFunc(flags caddycmd.Flags) (int, error) {
+	if flags.Arg(0) == "render" {
+		// Accept flags after the subcommand as well
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return 1, err
+		}
+		return cmdRender(flags)
+	}
+
+	if flags.Arg(0) == "lint" {
+		// Accept flags after the subcommand as well
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return 1, err
+		}
+		return cmdLint(flags)
+	}
+
 	caddy.TrapSignals()
 
 	options := createOptions(flags)
@@ -66,14 +138,56 @@ func cmdFunc(flags caddycmd.Flags) (int, error) {
 	if options.Mode&config.Server == config.Server {
 		log.Info("Running caddy proxy server")
 
-		err := caddy.Run(&caddy.Config{
-			Admin: &caddy.AdminConfig{
-				Listen: getAdminListen(options),
-			},
-		})
+		adminListen := getAdminListen(options)
+
+		adminTLS, err := LoadAdminTLS(options, config.Server)
 		if err != nil {
 			return 1, err
 		}
+		if options.Mode == config.Server && options.AdminTLSCAKey != "" {
+			log.Warn("Servers with the admin TLS CA key can issue controller certificates, use admin-tls-cert and admin-tls-key instead")
+		}
+
+		// When a secret or TLS is defined, caddy admin stays local and
+		// controllers go through a proxy that authenticates them
+		var adminProxy *AdminProxy
+		if adminIsProxied(options) && adminListen != localAdminListen {
+			adminProxy = CreateAdminProxy(options, strings.TrimPrefix(adminListen, "tcp/"), adminTLS)
+			adminListen = localAdminListen
+		}
+
+		// Servers resume serving their last configuration while controllers are unavailable
+		loaded := false
+		if options.Mode == config.Server {
+			loaded, err = loadLastConfig(adminListen)
+			if err != nil {
+				log.Error("Failed to load last configuration", zap.String("file", caddy.ConfigAutosavePath), zap.Error(err))
+			} else if loaded {
+				log.Info("Loaded last configuration", zap.String("file", caddy.ConfigAutosavePath))
+			}
+		}
+
+		if !loaded {
+			// Don't autosave the empty configuration over the last one
+			persist := false
+			err = caddy.Run(&caddy.Config{
+				Admin: &caddy.AdminConfig{
+					Listen: adminListen,
+					Config: &caddy.ConfigSettings{
+						Persist: &persist,
+					},
+				},
+			})
+			if err != nil {
+				return 1, err
+			}
+		}
+
+		if adminProxy != nil {
+			if err := adminProxy.Start(); err != nil {
+				return 1, err
+			}
+		}
 	}
 
 	if options.Mode&config.Controller == config.Controller {
@@ -92,7 +206,7 @@ func cmdFunc(flags caddycmd.Flags) (int, error) {
 }
 
 func getAdminListen(options *config.Options) string {
-	if options.ControllerNetwork != nil {
+	if len(options.ControllerNetworks) > 0 {
 		ifaces, err := net.Interfaces()
 		log := logger()
 
@@ -108,31 +222,45 @@ func getAdminListen(options *config.Options) string {
 			for _, a := range addrs {
 				switch v := a.(type) {
 				case *net.IPAddr:
-					if options.ControllerNetwork.Contains(v.IP) {
-						return "tcp/" + v.IP.String() + ":2019"
+					if options.InControllerNetworks(v.IP) {
+						return "tcp/" + net.JoinHostPort(v.IP.String(), "2019")
 					}
 					break
 				case *net.IPNet:
-					if options.ControllerNetwork.Contains(v.IP) {
-						return "tcp/" + v.IP.String() + ":2019"
+					if options.InControllerNetworks(v.IP) {
+						return "tcp/" + net.JoinHostPort(v.IP.String(), "2019")
 					}
 					break
 				}
 			}
 		}
 	}
-	return "tcp/localhost:2019"
+	return localAdminListen
 }
 
 func createOptions(flags caddycmd.Flags) *config.Options {
 	caddyfilePath := flags.String("caddyfile-path")
+	staticContainersPathFlag := flags.String("static-containers-path")
 	labelPrefixFlag := flags.String("label-prefix")
 	proxyServiceTasksFlag := flags.Bool("proxy-service-tasks")
 	processCaddyfileFlag := flags.Bool("process-caddyfile")
+	provenanceCommentsFlag := flags.Bool("provenance-comments")
 	pollingIntervalFlag := flags.Duration("polling-interval")
+	drainTimeoutFlag := flags.Duration("drain-timeout")
+	leaderLeasePathFlag := flags.String("leader-lease-path")
+	leaderLeaseDurationFlag := flags.Duration("leader-lease-duration")
 	modeFlag := flags.String("mode")
 	controllerSubnetFlag := flags.String("controller-network")
 	ingressNetworksFlag := flags.String("ingress-networks")
+	ipVersionFlag := flags.String("ip-version")
+	secretFlag := flags.String("secret")
+	adminTLSCAFlag := flags.String("admin-tls-ca")
+	adminTLSCAKeyFlag := flags.String("admin-tls-ca-key")
+	adminTLSCertFlag := flags.String("admin-tls-cert")
+	adminTLSKeyFlag := flags.String("admin-tls-key")
+	statusListenFlag := flags.String("status-listen")
+	rejectionWebhookFlag := flags.String("rejection-webhook")
+	configHistoryFlag := flags.Int("config-history")
 
 	options := &config.Options{}
 
@@ -155,21 +283,43 @@ func createOptions(flags caddycmd.Flags) *config.Options {
 	log := logger()
 
 	if controllerIPRangeEnv := os.Getenv("CADDY_CONTROLLER_NETWORK"); controllerIPRangeEnv != "" {
-		_, ipNet, err := net.ParseCIDR(controllerIPRangeEnv)
-		if err != nil {
-			log.Error("Failed to parse CADDY_CONTROLLER_NETWORK", zap.String("CADDY_CONTROLLER_NETWORK", controllerIPRangeEnv), zap.Error(err))
-		} else if ipNet != nil {
-			options.ControllerNetwork = ipNet
+		for _, controllerIPRange := range strings.Split(controllerIPRangeEnv, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(controllerIPRange))
+			if err != nil {
+				log.Error("Failed to parse CADDY_CONTROLLER_NETWORK", zap.String("CADDY_CONTROLLER_NETWORK", controllerIPRangeEnv), zap.Error(err))
+			} else if ipNet != nil {
+				options.ControllerNetworks = append(options.ControllerNetworks, ipNet)
+			}
 		}
 	} else if controllerSubnetFlag != "" {
-		_, ipNet, err := net.ParseCIDR(controllerSubnetFlag)
-		if err != nil {
-			log.Error("Failed to parse controller-network", zap.String("controller-network", controllerSubnetFlag), zap.Error(err))
-		} else if ipNet != nil {
-			options.ControllerNetwork = ipNet
+		for _, controllerSubnet := range strings.Split(controllerSubnetFlag, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(controllerSubnet))
+			if err != nil {
+				log.Error("Failed to parse controller-network", zap.String("controller-network", controllerSubnetFlag), zap.Error(err))
+			} else if ipNet != nil {
+				options.ControllerNetworks = append(options.ControllerNetworks, ipNet)
+			}
 		}
 	}
 
+	var ipVersion string
+	if ipVersionEnv := os.Getenv("CADDY_DOCKER_IP_VERSION"); ipVersionEnv != "" {
+		ipVersion = ipVersionEnv
+	} else {
+		ipVersion = ipVersionFlag
+	}
+	switch ipVersion {
+	case "ipv4":
+		options.IPVersion = config.PreferIPv4
+	case "ipv6":
+		options.IPVersion = config.PreferIPv6
+	case "both":
+		options.IPVersion = config.BothIPVersions
+	default:
+		log.Error("Invalid ip-version, using ipv4", zap.String("ip-version", ipVersion))
+		options.IPVersion = config.PreferIPv4
+	}
+
 	if ingressNetworksEnv := os.Getenv("CADDY_INGRESS_NETWORKS"); ingressNetworksEnv != "" {
 		options.IngressNetworks = strings.Split(ingressNetworksEnv, ",")
 	} else if ingressNetworksFlag != "" {
@@ -182,12 +332,21 @@ func createOptions(flags caddycmd.Flags) *config.Options {
 		options.CaddyfilePath = caddyfilePath
 	}
 
+	if staticContainersPathEnv := os.Getenv("CADDY_DOCKER_STATIC_CONTAINERS_PATH"); staticContainersPathEnv != "" {
+		options.StaticContainersPath = staticContainersPathEnv
+	} else {
+		options.StaticContainersPath = staticContainersPathFlag
+	}
+
 	if labelPrefixEnv := os.Getenv("CADDY_DOCKER_LABEL_PREFIX"); labelPrefixEnv != "" {
 		options.LabelPrefix = labelPrefixEnv
 	} else {
 		options.LabelPrefix = labelPrefixFlag
 	}
 	options.ControlledServersLabel = options.LabelPrefix + "_controlled_server"
+	options.IgnoreHealthLabel = options.LabelPrefix + "_ignore_health"
+	options.DrainLabel = options.LabelPrefix + "_drain"
+	options.DialLabel = options.LabelPrefix + "_dial"
 
 	if proxyServiceTasksEnv := os.Getenv("CADDY_DOCKER_PROXY_SERVICE_TASKS"); proxyServiceTasksEnv != "" {
 		options.ProxyServiceTasks = isTrue.MatchString(proxyServiceTasksEnv)
@@ -201,6 +360,12 @@ func createOptions(flags caddycmd.Flags) *config.Options {
 		options.ProcessCaddyfile = processCaddyfileFlag
 	}
 
+	if provenanceCommentsEnv := os.Getenv("CADDY_DOCKER_PROVENANCE_COMMENTS"); provenanceCommentsEnv != "" {
+		options.ProvenanceComments = isTrue.MatchString(provenanceCommentsEnv)
+	} else {
+		options.ProvenanceComments = provenanceCommentsFlag
+	}
+
 	if pollingIntervalEnv := os.Getenv("CADDY_DOCKER_POLLING_INTERVAL"); pollingIntervalEnv != "" {
 		if p, err := time.ParseDuration(pollingIntervalEnv); err != nil {
 			log.Error("Failed to parse CADDY_DOCKER_POLLING_INTERVAL", zap.String("CADDY_DOCKER_POLLING_INTERVAL", pollingIntervalEnv), zap.Error(err))
@@ -212,5 +377,90 @@ func createOptions(flags caddycmd.Flags) *config.Options {
 		options.PollingInterval = pollingIntervalFlag
 	}
 
+	if drainTimeoutEnv := os.Getenv("CADDY_DOCKER_DRAIN_TIMEOUT"); drainTimeoutEnv != "" {
+		if d, err := time.ParseDuration(drainTimeoutEnv); err != nil {
+			log.Error("Failed to parse CADDY_DOCKER_DRAIN_TIMEOUT", zap.String("CADDY_DOCKER_DRAIN_TIMEOUT", drainTimeoutEnv), zap.Error(err))
+			options.DrainTimeout = drainTimeoutFlag
+		} else {
+			options.DrainTimeout = d
+		}
+	} else {
+		options.DrainTimeout = drainTimeoutFlag
+	}
+
+	if leaderLeasePathEnv := os.Getenv("CADDY_DOCKER_LEADER_LEASE_PATH"); leaderLeasePathEnv != "" {
+		options.LeaderLeasePath = leaderLeasePathEnv
+	} else {
+		options.LeaderLeasePath = leaderLeasePathFlag
+	}
+
+	if leaderLeaseDurationEnv := os.Getenv("CADDY_DOCKER_LEADER_LEASE_DURATION"); leaderLeaseDurationEnv != "" {
+		if d, err := time.ParseDuration(leaderLeaseDurationEnv); err != nil {
+			log.Error("Failed to parse CADDY_DOCKER_LEADER_LEASE_DURATION", zap.String("CADDY_DOCKER_LEADER_LEASE_DURATION", leaderLeaseDurationEnv), zap.Error(err))
+			options.LeaderLeaseDuration = leaderLeaseDurationFlag
+		} else {
+			options.LeaderLeaseDuration = d
+		}
+	} else {
+		options.LeaderLeaseDuration = leaderLeaseDurationFlag
+	}
+	if options.LeaderLeaseDuration < minLeaderLeaseDuration {
+		log.Error("Invalid leader-lease-duration, using default", zap.Duration("leader-lease-duration", options.LeaderLeaseDuration), zap.Duration("minimum", minLeaderLeaseDuration))
+		options.LeaderLeaseDuration = defaultLeaderLeaseDuration
+	}
+
+	if secretEnv := os.Getenv("CADDY_DOCKER_SECRET"); secretEnv != "" {
+		options.Secret = secretEnv
+	} else {
+		options.Secret = secretFlag
+	}
+
+	if adminTLSCAEnv := os.Getenv("CADDY_DOCKER_ADMIN_TLS_CA"); adminTLSCAEnv != "" {
+		options.AdminTLSCA = adminTLSCAEnv
+	} else {
+		options.AdminTLSCA = adminTLSCAFlag
+	}
+
+	if adminTLSCAKeyEnv := os.Getenv("CADDY_DOCKER_ADMIN_TLS_CA_KEY"); adminTLSCAKeyEnv != "" {
+		options.AdminTLSCAKey = adminTLSCAKeyEnv
+	} else {
+		options.AdminTLSCAKey = adminTLSCAKeyFlag
+	}
+
+	if adminTLSCertEnv := os.Getenv("CADDY_DOCKER_ADMIN_TLS_CERT"); adminTLSCertEnv != "" {
+		options.AdminTLSCert = adminTLSCertEnv
+	} else {
+		options.AdminTLSCert = adminTLSCertFlag
+	}
+
+	if adminTLSKeyEnv := os.Getenv("CADDY_DOCKER_ADMIN_TLS_KEY"); adminTLSKeyEnv != "" {
+		options.AdminTLSKey = adminTLSKeyEnv
+	} else {
+		options.AdminTLSKey = adminTLSKeyFlag
+	}
+
+	if statusListenEnv := os.Getenv("CADDY_DOCKER_STATUS_LISTEN"); statusListenEnv != "" {
+		options.StatusListen = statusListenEnv
+	} else {
+		options.StatusListen = statusListenFlag
+	}
+
+	if rejectionWebhookEnv := os.Getenv("CADDY_DOCKER_REJECTION_WEBHOOK"); rejectionWebhookEnv != "" {
+		options.RejectionWebhook = rejectionWebhookEnv
+	} else {
+		options.RejectionWebhook = rejectionWebhookFlag
+	}
+
+	if configHistoryEnv := os.Getenv("CADDY_DOCKER_CONFIG_HISTORY"); configHistoryEnv != "" {
+		if n, err := strconv.Atoi(configHistoryEnv); err != nil {
+			log.Error("Failed to parse CADDY_DOCKER_CONFIG_HISTORY", zap.String("CADDY_DOCKER_CONFIG_HISTORY", configHistoryEnv), zap.Error(err))
+			options.ConfigHistory = configHistoryFlag
+		} else {
+			options.ConfigHistory = n
+		}
+	} else {
+		options.ConfigHistory = configHistoryFlag
+	}
+
 	return options
 }
@@ -8,17 +8,55 @@ import (
 // Options are the options for generator
 type Options struct {
 	CaddyfilePath          string
+	StaticContainersPath   string
 	LabelPrefix            string
 	ControlledServersLabel string
+	IgnoreHealthLabel      string
+	DrainLabel             string
+	DialLabel              string
 	ProxyServiceTasks      bool
 	ProcessCaddyfile       bool
+	ProvenanceComments     bool
 	PollingInterval        time.Duration
+	DrainTimeout           time.Duration
+	LeaderLeaseDuration    time.Duration
+	ConfigHistory          int
 	Mode                   Mode
 	Secret                 string
-	ControllerNetwork      *net.IPNet
+	AdminTLSCA             string
+	AdminTLSCAKey          string
+	AdminTLSCert           string
+	AdminTLSKey            string
+	ControllerNetworks     []*net.IPNet
 	IngressNetworks        []string
+	IPVersion              IPVersion
+	StatusListen           string
+	RejectionWebhook       string
+	LeaderLeasePath        string
 }
 
+// InControllerNetworks checks if an IP belongs to one of the controller networks
+func (options *Options) InControllerNetworks(ip net.IP) bool {
+	for _, network := range options.ControllerNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPVersion represents which IP addresses of containers and tasks are used
+type IPVersion int
+
+const (
+	// PreferIPv4 uses IPv4 addresses, falling back to IPv6 on networks without IPv4
+	PreferIPv4 IPVersion = iota
+	// PreferIPv6 uses IPv6 addresses, falling back to IPv4 on networks without IPv6
+	PreferIPv6
+	// BothIPVersions uses IPv4 and IPv6 addresses
+	BothIPVersions
+)
+
 // Mode represents how this instance should run
 type Mode int
 
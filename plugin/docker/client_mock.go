@@ -24,12 +24,26 @@ type ClientMock struct {
 
 // ContainerList list all containers
 func (mock *ClientMock) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
-	return mock.ContainersData, nil
+	matchingContainers := []types.Container{}
+	for _, container := range mock.ContainersData {
+		if !options.Filters.ExactMatch("id", container.ID) {
+			continue
+		}
+		matchingContainers = append(matchingContainers, container)
+	}
+	return matchingContainers, nil
 }
 
 // ServiceList list all services
 func (mock *ClientMock) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
-	return mock.ServicesData, nil
+	matchingServices := []swarm.Service{}
+	for _, service := range mock.ServicesData {
+		if !options.Filters.ExactMatch("id", service.ID) {
+			continue
+		}
+		matchingServices = append(matchingServices, service)
+	}
+	return matchingServices, nil
 }
 
 // TaskList list all tasks
@@ -49,7 +63,14 @@ func (mock *ClientMock) TaskList(ctx context.Context, options types.TaskListOpti
 
 // ConfigList list all configs
 func (mock *ClientMock) ConfigList(ctx context.Context, options types.ConfigListOptions) ([]swarm.Config, error) {
-	return mock.ConfigsData, nil
+	matchingConfigs := []swarm.Config{}
+	for _, config := range mock.ConfigsData {
+		if !options.Filters.ExactMatch("id", config.ID) {
+			continue
+		}
+		matchingConfigs = append(matchingConfigs, config)
+	}
+	return matchingConfigs, nil
 }
 
 // NetworkList list all networks
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Interval between checks of in-flight requests of draining containers
+const drainCheckInterval = 1 * time.Second
+
+// upstreamStatus is an upstream as reported by caddy admin endpoint /reverse_proxy/upstreams
+type upstreamStatus struct {
+	Address     string `json:"address"`
+	NumRequests int    `json:"num_requests"`
+}
+
+// checkDrains ends drains of containers that timed out or have no in-flight requests in any server.
+// Only the leader checks in-flight requests, followers end drains when they time out.
+func (dockerLoader *DockerLoader) checkDrains(servers []string) {
+	log := logger()
+
+	draining := dockerLoader.generator.Draining()
+	if len(draining) == 0 {
+		return
+	}
+
+	checkRequests := dockerLoader.isLeader()
+	var activeRequests map[string]int
+	if checkRequests {
+		var err error
+		activeRequests, err = dockerLoader.getActiveRequests(servers)
+		if err != nil {
+			log.Warn("Failed to get in-flight requests of draining containers", zap.Error(err))
+			checkRequests = false
+		}
+	}
+
+	for _, container := range draining {
+		if time.Since(container.Since) >= dockerLoader.options.DrainTimeout {
+			log.Info("Container drain timed out", zap.String("container", container.ID))
+			dockerLoader.generator.EndDrain(container.ID)
+			continue
+		}
+		if checkRequests {
+			requests := 0
+			for _, upstream := range container.Upstreams {
+				requests += activeRequests[upstream]
+			}
+			if requests == 0 {
+				log.Info("Container drained", zap.String("container", container.ID))
+				dockerLoader.generator.EndDrain(container.ID)
+				continue
+			}
+		}
+	}
+}
+
+// getActiveRequests sums in-flight requests of upstreams in all servers, by address and by host.
+// Container upstreams have a port when it comes from dial labels, and only a host otherwise.
+func (dockerLoader *DockerLoader) getActiveRequests(servers []string) (map[string]int, error) {
+	activeRequests := map[string]int{}
+	for _, server := range servers {
+		upstreams, err := dockerLoader.getUpstreams(server)
+		if err != nil {
+			return nil, err
+		}
+		for _, upstream := range upstreams {
+			activeRequests[upstream.Address] += upstream.NumRequests
+			if host, _, err := net.SplitHostPort(upstream.Address); err == nil {
+				activeRequests[host] += upstream.NumRequests
+			}
+		}
+	}
+	return activeRequests, nil
+}
+
+func (dockerLoader *DockerLoader) getUpstreams(server string) ([]upstreamStatus, error) {
+	req, err := dockerLoader.newAdminRequest("GET", server, "/reverse_proxy/upstreams", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dockerLoader.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Server %s responded with status %d: %s", server, resp.StatusCode, body)
+	}
+
+	upstreams := []upstreamStatus{}
+	if err := json.Unmarshal(body, &upstreams); err != nil {
+		return nil, err
+	}
+	return upstreams, nil
+}
@@ -0,0 +1,82 @@
+package generator
+
+import "sort"
+
+// cachedResource is what a single docker resource contributed to the last generation
+type cachedResource struct {
+	id                string
+	fragment          *Fragment
+	controlledServers []string
+	// dynamic resources reference other resources, and are refreshed whenever a resource changes
+	dynamic bool
+}
+
+// resourceCache keeps resources collected by a source
+type resourceCache struct {
+	initialized bool
+	resources   []*cachedResource
+}
+
+func newResourceCache() *resourceCache {
+	return &resourceCache{
+		resources: []*cachedResource{},
+	}
+}
+
+// reset drops all resources, marking the cache as initialized by a full listing
+func (cache *resourceCache) reset() {
+	cache.initialized = true
+	cache.resources = []*cachedResource{}
+}
+
+// invalidate drops all resources, forcing the next collect to be a full listing
+func (cache *resourceCache) invalidate() {
+	cache.reset()
+	cache.initialized = false
+}
+
+// add a resource found by a full listing
+func (cache *resourceCache) add(resource *cachedResource) {
+	cache.resources = append(cache.resources, resource)
+}
+
+// set replaces a resource with the same ID, or adds it
+func (cache *resourceCache) set(resource *cachedResource) {
+	for i, cachedResource := range cache.resources {
+		if cachedResource.id == resource.id {
+			cache.resources[i] = resource
+			return
+		}
+	}
+	cache.add(resource)
+}
+
+func (cache *resourceCache) delete(id string) {
+	resources := []*cachedResource{}
+	for _, resource := range cache.resources {
+		if resource.id != id {
+			resources = append(resources, resource)
+		}
+	}
+	cache.resources = resources
+}
+
+// collect returns fragments and controlled servers of all cached resources, ordered by resource ID.
+// Full listings and incremental changes add resources in different orders, but must generate the same caddyfile.
+func (cache *resourceCache) collect() ([]*Fragment, []string) {
+	resources := make([]*cachedResource, len(cache.resources))
+	copy(resources, cache.resources)
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].id < resources[j].id
+	})
+
+	fragments := []*Fragment{}
+	controlledServers := []string{}
+	for _, resource := range resources {
+		if resource.fragment != nil {
+			fragments = append(fragments, resource.fragment)
+		}
+		controlledServers = append(controlledServers, resource.controlledServers...)
+	}
+	return fragments, controlledServers
+}
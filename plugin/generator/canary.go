@@ -0,0 +1,176 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+)
+
+// maxWeightedUpstreams bounds how many upstreams weighting adds to a reverse_proxy,
+// rounding weights of reverse_proxy directives with many upstreams
+const maxWeightedUpstreams = 100
+
+// canaryDirective marks a site as canary of the other sources sharing its address, like:
+// caddy.canary.weight=10
+const canaryDirective = "canary"
+
+// canarySite keeps the weight and upstreams of canary sources of a site
+type canarySite struct {
+	weight    int
+	upstreams map[string]bool
+}
+
+// canaries keeps canary sites by site address
+type canaries map[string]*canarySite
+
+// extractCanary removes canary directives from sites of a caddyfile, returning canary weights by site address
+// and the invalid canary directives that were removed
+func extractCanary(container *caddyfile.Container) (map[string]int, []RemovedBlock) {
+	weights := map[string]int{}
+	removedBlocks := []RemovedBlock{}
+	for _, site := range container.Children {
+		for _, directive := range site.GetAllByFirstKey(canaryDirective) {
+			site.Remove(directive)
+			weight, err := getCanaryWeight(directive)
+			if err != nil {
+				removedBlocks = append(removedBlocks, RemovedBlock{
+					Block:      string(directive.Marshal()),
+					Error:      err.Error(),
+					Provenance: directive.GetAllProvenance(),
+				})
+				continue
+			}
+			weights[strings.Join(site.Keys, " ")] = weight
+		}
+	}
+	return weights, removedBlocks
+}
+
+func getCanaryWeight(directive *caddyfile.Block) (int, error) {
+	weightBlocks := directive.GetAllByFirstKey("weight")
+	if len(weightBlocks) != 1 || len(weightBlocks[0].Keys) != 2 {
+		return 0, fmt.Errorf("canary requires a single weight between 0 and 100")
+	}
+	weight, err := strconv.Atoi(weightBlocks[0].Keys[1])
+	if err != nil || weight < 0 || weight > 100 {
+		return 0, fmt.Errorf("invalid canary weight %s, expected a number between 0 and 100", weightBlocks[0].Keys[1])
+	}
+	return weight, nil
+}
+
+// add records upstreams of canary sites of a caddyfile
+func (canaries canaries) add(container *caddyfile.Container, weights map[string]int) {
+	for _, site := range container.Children {
+		address := strings.Join(site.Keys, " ")
+		weight, isCanary := weights[address]
+		if !isCanary {
+			continue
+		}
+		canary, exists := canaries[address]
+		if !exists {
+			canary = &canarySite{weight: weight, upstreams: map[string]bool{}}
+			canaries[address] = canary
+		}
+		for _, upstream := range getReverseProxyUpstreams(site) {
+			canary.upstreams[upstream] = true
+		}
+	}
+}
+
+// apply weights upstreams of merged sites, splitting traffic between canary upstreams and the other ones
+func (canaries canaries) apply(container *caddyfile.Container) {
+	for _, site := range container.Children {
+		if canary, exists := canaries[strings.Join(site.Keys, " ")]; exists {
+			applyCanary(site, canary)
+		}
+	}
+}
+
+func applyCanary(block *caddyfile.Block, canary *canarySite) {
+	for _, child := range block.Children {
+		if child.GetFirstKey() != "reverse_proxy" {
+			applyCanary(child, canary)
+			continue
+		}
+		keys := []string{"reverse_proxy"}
+		if getReverseProxyMatcher(child) != "" {
+			keys = append(keys, child.Keys[1])
+		}
+		stable, canaryUpstreams := []string{}, []string{}
+		for _, upstream := range child.Keys[len(keys):] {
+			if canary.upstreams[upstream] {
+				canaryUpstreams = append(canaryUpstreams, upstream)
+			} else {
+				stable = append(stable, upstream)
+			}
+		}
+		child.Keys = append(keys, weightUpstreams(stable, canaryUpstreams, canary.weight)...)
+	}
+}
+
+// weightUpstreams repeats upstreams so that canary upstreams receive weight percent of requests,
+// when load balancing requests evenly between all upstreams, like lb_policy random and round_robin.
+// Weights are rounded when exact repeats would add more than maxWeightedUpstreams upstreams.
+func weightUpstreams(stable []string, canary []string, weight int) []string {
+	if len(stable) == 0 || len(canary) == 0 {
+		return append(stable, canary...)
+	}
+	stableRepeats := len(canary) * (100 - weight)
+	canaryRepeats := len(stable) * weight
+	divisor := gcd(stableRepeats, canaryRepeats)
+	stableRepeats, canaryRepeats = stableRepeats/divisor, canaryRepeats/divisor
+	if stableRepeats*len(stable)+canaryRepeats*len(canary) > maxWeightedUpstreams {
+		stableRepeats = roundedRepeats(maxWeightedUpstreams*(100-weight)/100, len(stable))
+		canaryRepeats = roundedRepeats(maxWeightedUpstreams*weight/100, len(canary))
+	}
+	weighted := []string{}
+	for i := 0; i < stableRepeats; i++ {
+		weighted = append(weighted, stable...)
+	}
+	for i := 0; i < canaryRepeats; i++ {
+		weighted = append(weighted, canary...)
+	}
+	return weighted
+}
+
+// roundedRepeats rounds how many times upstreams are repeated to fill slots, repeating them at least once
+func roundedRepeats(slots int, upstreams int) int {
+	repeats := (slots + upstreams/2) / upstreams
+	if repeats < 1 {
+		return 1
+	}
+	return repeats
+}
+
+func gcd(a int, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// getReverseProxyUpstreams gets upstreams of all reverse_proxy directives of a block and its descendants
+func getReverseProxyUpstreams(block *caddyfile.Block) []string {
+	upstreams := []string{}
+	for _, child := range block.Children {
+		if child.GetFirstKey() != "reverse_proxy" {
+			upstreams = append(upstreams, getReverseProxyUpstreams(child)...)
+			continue
+		}
+		start := 1
+		if getReverseProxyMatcher(child) != "" {
+			start = 2
+		}
+		upstreams = append(upstreams, child.Keys[start:]...)
+	}
+	return upstreams
+}
+
+func getReverseProxyMatcher(block *caddyfile.Block) string {
+	if len(block.Keys) > 1 && (block.Keys[1] == "*" || strings.HasPrefix(block.Keys[1], "/") || strings.HasPrefix(block.Keys[1], "@")) {
+		return block.Keys[1]
+	}
+	return ""
+}
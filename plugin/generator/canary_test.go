@@ -0,0 +1,115 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCanary_Weight(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		// Canary is weighted regardless of merge order
+		createNetworkContainer("CANARY", "canary", "172.17.0.4", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams 8080}}",
+			fmtLabel("%s.canary.weight"): "25",
+		}),
+		createNetworkContainer("STABLE-1", "stable-1", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams 8080}}",
+		}),
+		createNetworkContainer("STABLE-2", "stable-2", "172.17.0.3", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams 8080}}",
+		}),
+	}
+
+	const expectedCaddyfile = "example.com {\n" +
+		"	reverse_proxy 172.17.0.2:8080 172.17.0.3:8080 172.17.0.2:8080 172.17.0.3:8080 172.17.0.2:8080 172.17.0.3:8080 172.17.0.4:8080 172.17.0.4:8080\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+}
+
+func TestCanary_InvalidWeight(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createNetworkContainer("CANARY", "canary", "172.17.0.3", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			fmtLabel("%s.canary.weight"): "150",
+		}),
+		createNetworkContainer("STABLE", "stable", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+		}),
+	}
+
+	const expectedCaddyfile = "example.com {\n" +
+		"	reverse_proxy 172.17.0.3 172.17.0.2\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog +
+		`ERROR	Invalid canary	{"kind": "container", "name": "canary", "error": "invalid canary weight 150, expected a number between 0 and 100"}` + newLine
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+
+	generation := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	}).Generate(zap.NewNop())
+	assert.Equal(t, []RemovedBlock{
+		{
+			Block: "canary {\n\tweight 150\n}\n",
+			Error: "invalid canary weight 150, expected a number between 0 and 100",
+			Provenance: []*caddyfile.Provenance{
+				{Kind: "container", ID: "CANARY", Name: "canary", Label: "caddy.canary"},
+				{Kind: "container", ID: "CANARY", Name: "canary", Label: "caddy.canary.weight"},
+			},
+		},
+	}, generation.RemovedBlocks)
+}
+
+func TestCanary_WeightUpstreams(t *testing.T) {
+	assert.Equal(t, []string{"a", "a", "a", "a", "a", "a", "a", "a", "a", "b"}, weightUpstreams([]string{"a"}, []string{"b"}, 10))
+	assert.Equal(t, []string{"a"}, weightUpstreams([]string{"a"}, []string{"b"}, 0))
+	assert.Equal(t, []string{"b"}, weightUpstreams([]string{"a"}, []string{"b"}, 100))
+	assert.Equal(t, []string{"b", "c"}, weightUpstreams([]string{}, []string{"b", "c"}, 10))
+}
+
+func TestCanary_WeightUpstreamsBounded(t *testing.T) {
+	upstreams := func(prefix string, count int) []string {
+		result := []string{}
+		for i := 0; i < count; i++ {
+			result = append(result, fmt.Sprintf("%s%d", prefix, i))
+		}
+		return result
+	}
+	count := func(weighted []string, prefix string) int {
+		result := 0
+		for _, upstream := range weighted {
+			if strings.HasPrefix(upstream, prefix) {
+				result++
+			}
+		}
+		return result
+	}
+
+	// Exact weighting would repeat 3 stable upstreams 469 times and 7 canary upstreams 99 times
+	weighted := weightUpstreams(upstreams("s", 3), upstreams("c", 7), 33)
+	assert.Equal(t, 66, count(weighted, "s"))
+	assert.Equal(t, 35, count(weighted, "c"))
+
+	// Every upstream is kept at least once
+	weighted = weightUpstreams(upstreams("s", 150), upstreams("c", 7), 1)
+	assert.Equal(t, 150, count(weighted, "s"))
+	assert.Equal(t, 7, count(weighted, "c"))
+}
@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Labels docker compose adds to containers
+const composeProjectLabel = "com.docker.compose.project"
+const composeServiceLabel = "com.docker.compose.service"
+const composeContainerNumberLabel = "com.docker.compose.container-number"
+
+// ContainerTemplateData is the data available to templates in container labels.
+// Besides docker container fields, it exposes docker compose metadata.
+type ContainerTemplateData struct {
+	types.Container
+	ComposeProject  string
+	ComposeService  string
+	ContainerNumber string
+	ShortID         string
+}
+
+// CreateContainerTemplateData creates template data of a container
+func CreateContainerTemplateData(container *types.Container) *ContainerTemplateData {
+	shortID := container.ID
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+	return &ContainerTemplateData{
+		Container:       *container,
+		ComposeProject:  container.Labels[composeProjectLabel],
+		ComposeService:  container.Labels[composeServiceLabel],
+		ContainerNumber: container.Labels[composeContainerNumberLabel],
+		ShortID:         shortID,
+	}
+}
+
+// sortContainers sorts containers by compose container number, then by ID
+func sortContainers(containers []*types.Container) {
+	sort.SliceStable(containers, func(i, j int) bool {
+		numberI, _ := strconv.Atoi(containers[i].Labels[composeContainerNumberLabel])
+		numberJ, _ := strconv.Atoi(containers[j].Labels[composeContainerNumberLabel])
+		if numberI != numberJ {
+			return numberI < numberJ
+		}
+		return containers[i].ID < containers[j].ID
+	})
+}
@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func createComposeContainer(id string, service string, number string, ip string) types.Container {
+	return createNetworkContainer(id, "", ip, map[string]string{
+		composeProjectLabel:          "project",
+		composeServiceLabel:          service,
+		composeContainerNumberLabel:  number,
+		fmtLabel("%s"):               "{{.ComposeService}}.{{.ComposeProject}}.example.com",
+		fmtLabel("%s.reverse_proxy"): "{{composeUpstreams 8080}}",
+		fmtLabel("%s.header"):        "X-Replica {{.ContainerNumber}}-{{.ShortID}}",
+	})
+}
+
+func TestCompose_TemplateData(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createComposeContainer("0123456789abcdef", "web", "1", "172.17.0.2"),
+	}
+
+	const expectedCaddyfile = "web.project.example.com {\n" +
+		"	header X-Replica 1-0123456789ab\n" +
+		"	reverse_proxy 172.17.0.2:8080\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+}
+
+func TestCompose_Upstreams(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createComposeContainer("B", "web", "2", "172.17.0.3"),
+		createComposeContainer("A", "web", "1", "172.17.0.2"),
+		createComposeContainer("C", "api", "1", "172.17.0.4"),
+	}
+	for i := range dockerClient.ContainersData {
+		delete(dockerClient.ContainersData[i].Labels, fmtLabel("%s.header"))
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	})
+
+	generation := generator.Generate(zap.NewNop())
+	assert.Equal(t, "api.project.example.com {\n"+
+		"	reverse_proxy 172.17.0.4:8080\n"+
+		"}\n"+
+		"web.project.example.com {\n"+
+		"	reverse_proxy 172.17.0.2:8080 172.17.0.3:8080\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Remaining replicas are refreshed when a replica is removed
+	dockerClient.ContainersData = dockerClient.ContainersData[1:]
+	generator.Invalidate("container", "B")
+
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "api.project.example.com {\n"+
+		"	reverse_proxy 172.17.0.4:8080\n"+
+		"}\n"+
+		"web.project.example.com {\n"+
+		"	reverse_proxy 172.17.0.2:8080\n"+
+		"}\n", string(generation.Caddyfile))
+}
+
+func TestCompose_DrainingReplica(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createComposeContainer("A", "web", "1", "172.17.0.2"),
+		createComposeContainer("B", "web", "2", "172.17.0.3"),
+	}
+	for i := range dockerClient.ContainersData {
+		delete(dockerClient.ContainersData[i].Labels, fmtLabel("%s.header"))
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix:  DefaultLabelPrefix,
+		DrainTimeout: time.Minute,
+	})
+	generator.Generate(zap.NewNop())
+
+	// Stopped replica drains in the remaining replicas caddyfile as well
+	generator.Drain("B")
+	generator.Invalidate("container", "B")
+	generator.GenerateIncremental(zap.NewNop())
+	dockerClient.ContainersData = dockerClient.ContainersData[:1]
+	generator.Invalidate("container", "B")
+
+	const drainingCaddyfile = "web.project.example.com {\n" +
+		"	@caddy_docker_proxy_draining not path *\n" +
+		"	reverse_proxy 172.17.0.2:8080\n" +
+		"	reverse_proxy @caddy_docker_proxy_draining 172.17.0.3:8080\n" +
+		"}\n"
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, drainingCaddyfile, string(generation.Caddyfile))
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, drainingCaddyfile, string(generation.Caddyfile))
+
+	generator.EndDrain("B")
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "web.project.example.com {\n"+
+		"	reverse_proxy 172.17.0.2:8080\n"+
+		"}\n", string(generation.Caddyfile))
+}
@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+
+	"go.uber.org/zap"
+)
+
+// configsSource provides caddyfiles from swarm configs
+type configsSource struct {
+	g     *CaddyfileGenerator
+	cache *resourceCache
+}
+
+func (source *configsSource) Kind() string {
+	return "config"
+}
+
+func (source *configsSource) Collect(logger *zap.Logger) ([]*Fragment, []string) {
+	g := source.g
+
+	if !g.swarmIsAvailable {
+		logger.Info("Skipping swarm config caddyfiles because swarm is not available")
+		source.cache.invalidate()
+		return []*Fragment{}, nil
+	}
+
+	configs, err := g.dockerClient.ConfigList(context.Background(), types.ConfigListOptions{})
+	if err != nil {
+		logger.Error("Failed to get Swarm configs", zap.Error(err))
+		source.cache.invalidate()
+		return []*Fragment{}, nil
+	}
+
+	source.cache.reset()
+	for _, config := range configs {
+		if resource := source.collectConfig(&config, logger); resource != nil {
+			source.cache.add(resource)
+		}
+	}
+
+	fragments, _ := source.cache.collect()
+	return fragments, nil
+}
+
+func (source *configsSource) CollectChanged(logger *zap.Logger, ids []string) ([]*Fragment, []string) {
+	if !source.cache.initialized {
+		return source.Collect(logger)
+	}
+
+	g := source.g
+
+	for _, id := range ids {
+		configs, err := g.dockerClient.ConfigList(context.Background(), types.ConfigListOptions{
+			Filters: filters.NewArgs(filters.Arg("id", id)),
+		})
+		if err != nil {
+			logger.Error("Failed to get Swarm configs", zap.String("configId", id), zap.Error(err))
+			return source.Collect(logger)
+		}
+
+		if len(configs) == 0 {
+			source.cache.delete(id)
+		}
+		for _, config := range configs {
+			if resource := source.collectConfig(&config, logger); resource != nil {
+				source.cache.set(resource)
+			} else {
+				source.cache.delete(config.ID)
+			}
+		}
+	}
+
+	fragments, _ := source.cache.collect()
+	return fragments, nil
+}
+
+func (source *configsSource) CollectDynamic(logger *zap.Logger) ([]*Fragment, []string) {
+	// Config caddyfiles don't reference other resources
+	return source.cache.collect()
+}
+
+func (source *configsSource) collectConfig(config *swarm.Config, logger *zap.Logger) *cachedResource {
+	g := source.g
+
+	if _, hasLabel := config.Spec.Labels[g.options.LabelPrefix]; !hasLabel {
+		return nil
+	}
+
+	fullConfig, _, err := g.dockerClient.ConfigInspectWithRaw(context.Background(), config.ID)
+	if err != nil {
+		logger.Error("Failed to inspect Swarm Config", zap.String("config", config.Spec.Name), zap.Error(err))
+		return nil
+	}
+
+	block, err := caddyfile.Unmarshal(fullConfig.Spec.Data)
+	if err != nil {
+		logger.Error("Failed to parse Swarm Config caddyfile format", zap.String("config", config.Spec.Name), zap.Error(err))
+		return nil
+	}
+
+	return &cachedResource{
+		id: config.ID,
+		fragment: &Fragment{
+			Kind:      "config",
+			ID:        config.ID,
+			Name:      config.Spec.Name,
+			Caddyfile: block,
+		},
+	}
+}
@@ -1,17 +1,239 @@
 package generator
 
 import (
+	"context"
+	"net"
+	"strings"
+
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
 	"go.uber.org/zap"
 )
 
-func (g *CaddyfileGenerator) getContainerCaddyfile(container *types.Container, logger *zap.Logger) (*caddyfile.Container, error) {
+// containersSource provides caddyfiles from docker containers labels
+type containersSource struct {
+	g     *CaddyfileGenerator
+	cache *resourceCache
+	// containers of the last listing by ID, used to resolve upstreams of other containers
+	containers map[string]types.Container
+}
+
+func (source *containersSource) Kind() string {
+	return "container"
+}
+
+func (source *containersSource) Collect(logger *zap.Logger) ([]*Fragment, []string) {
+	g := source.g
+
+	containers, err := g.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		logger.Error("Failed to get ContainerList", zap.Error(err))
+		source.cache.invalidate()
+		return []*Fragment{}, []string{}
+	}
+
+	source.containers = map[string]types.Container{}
+	for _, container := range containers {
+		source.containers[container.ID] = container
+	}
+
+	source.cache.reset()
+	listed := map[string]bool{}
+	for _, container := range containers {
+		listed[container.ID] = true
+		source.cache.add(source.collectContainer(&container, logger))
+	}
+	for _, container := range g.unlistedDrains(listed) {
+		source.cache.add(source.collectContainer(container, logger))
+	}
+
+	return source.cache.collect()
+}
+
+func (source *containersSource) CollectChanged(logger *zap.Logger, ids []string) ([]*Fragment, []string) {
+	if !source.cache.initialized {
+		return source.Collect(logger)
+	}
+	if len(ids) == 0 {
+		return source.cache.collect()
+	}
+
+	g := source.g
+
+	// Update listing of all changed containers before generating caddyfiles that may reference them
+	for _, id := range ids {
+		containers, err := g.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{
+			Filters: filters.NewArgs(filters.Arg("id", id)),
+		})
+		if err != nil {
+			logger.Error("Failed to get ContainerList", zap.String("container", id), zap.Error(err))
+			return source.Collect(logger)
+		}
+
+		delete(source.containers, id)
+		for _, container := range containers {
+			source.containers[container.ID] = container
+		}
+	}
+
+	for _, id := range ids {
+		if container, listed := source.containers[id]; listed {
+			source.cache.set(source.collectContainer(&container, logger))
+		} else if container := g.getUnlistedDrain(id); container != nil {
+			// Stopped and removed containers are not listed, unless they are draining
+			source.cache.set(source.collectContainer(container, logger))
+		} else {
+			source.cache.delete(id)
+		}
+	}
+
+	return source.cache.collect()
+}
+
+func (source *containersSource) CollectDynamic(logger *zap.Logger) ([]*Fragment, []string) {
+	for _, resource := range source.cache.resources {
+		if !resource.dynamic {
+			continue
+		}
+		if container, listed := source.containers[resource.id]; listed {
+			source.cache.set(source.collectContainer(&container, logger))
+		}
+	}
+
+	return source.cache.collect()
+}
+
+func (source *containersSource) collectContainer(container *types.Container, logger *zap.Logger) *cachedResource {
+	g := source.g
+	resource := &cachedResource{
+		id:                container.ID,
+		controlledServers: []string{},
+	}
+
+	if _, isControlledServer := container.Labels[g.options.ControlledServersLabel]; isControlledServer {
+		ips, err := g.getContainerIPAddresses(container, logger, false)
+		if err != nil {
+			logger.Error("Failed to get Container IPs", zap.String("container", container.ID), zap.Error(err))
+		} else {
+			for _, ip := range ips {
+				if len(g.options.ControllerNetworks) == 0 || g.options.InControllerNetworks(net.ParseIP(ip)) {
+					resource.controlledServers = append(resource.controlledServers, ip)
+				}
+			}
+		}
+	}
+
+	if _, drain := container.Labels[g.options.DrainLabel]; drain && g.options.DrainLabel != "" {
+		g.Drain(container.ID)
+	} else {
+		g.clearEndedDrain(container.ID)
+	}
+
+	containerCaddyfile, err := source.getContainerCaddyfile(container, resource, logger)
+	if err != nil {
+		logger.Error("Failed to get Container Caddyfile", zap.String("container", container.ID), zap.Error(err))
+	}
+	g.updateDrain(container, containerCaddyfile, logger)
+	resource.fragment = &Fragment{
+		Kind:      "container",
+		ID:        container.ID,
+		Name:      getContainerName(container),
+		Caddyfile: containerCaddyfile,
+		Err:       err,
+	}
+
+	return resource
+}
+
+func (source *containersSource) getContainerCaddyfile(container *types.Container, resource *cachedResource, logger *zap.Logger) (*caddyfile.Container, error) {
+	g := source.g
 	caddyLabels := g.filterLabels(container.Labels)
 
-	return labelsToCaddyfile(caddyLabels, container, func() ([]string, error) {
-		return g.getContainerIPAddresses(container, logger, true)
-	})
+	getPort := func() (int, error) {
+		return getContainerPort(container)
+	}
+
+	funcs := g.referenceFuncs(resource, logger)
+	funcs["composeUpstreams"] = func(options ...interface{}) (string, error) {
+		resource.dynamic = true
+		targets, err := source.getComposeUpstreams(container, logger)
+		if err != nil {
+			return "", err
+		}
+		// Replicas share the same image and ports
+		return formatUpstreams(targets, options, getPort)
+	}
+
+	return labelsToCaddyfile(caddyLabels, CreateContainerTemplateData(container), func() ([]string, error) {
+		return g.getContainerUpstreams(container, logger)
+	}, getPort, funcs)
+}
+
+// getComposeUpstreams gets upstreams of all replicas of the compose service of a container
+func (source *containersSource) getComposeUpstreams(container *types.Container, logger *zap.Logger) ([]string, error) {
+	project, service := container.Labels[composeProjectLabel], container.Labels[composeServiceLabel]
+	if service == "" {
+		return source.g.getContainerUpstreams(container, logger)
+	}
+
+	// Draining replicas are kept, to be separated from the other ones
+	replicas := []*types.Container{}
+	for _, replica := range source.g.withUnlistedDraining(source.containers) {
+		if replica.Labels[composeProjectLabel] == project && replica.Labels[composeServiceLabel] == service {
+			replicas = append(replicas, replica)
+		}
+	}
+	sortContainers(replicas)
+
+	targets := []string{}
+	for _, replica := range replicas {
+		// Only log problems of the container being generated
+		replicaLogger := zap.NewNop()
+		if replica.ID == container.ID {
+			replicaLogger = logger
+		}
+		ips, err := source.g.getContainerUpstreams(replica, replicaLogger)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, ips...)
+	}
+	return targets, nil
+}
+
+// getContainerUpstreams gets the ingress IPs of a container, unless it is not healthy or drained
+func (g *CaddyfileGenerator) getContainerUpstreams(container *types.Container, logger *zap.Logger) ([]string, error) {
+	if !g.isContainerHealthy(container) {
+		logger.Info("Skipping upstreams of container that is not healthy", zap.String("container", container.ID), zap.String("status", container.Status))
+		return []string{}, nil
+	}
+	if g.isDrained(container.ID) {
+		logger.Info("Skipping upstreams of drained container", zap.String("container", container.ID))
+		return []string{}, nil
+	}
+	if dial, exists := container.Labels[g.options.DialLabel]; exists && g.options.DialLabel != "" {
+		return strings.Fields(strings.ReplaceAll(dial, ",", " ")), nil
+	}
+	if container.HostConfig.NetworkMode == "host" {
+		if g.hostGateway == "" {
+			logger.Warn("Container is in host network, but the host address is unknown", zap.String("container", container.ID))
+			return []string{}, nil
+		}
+		return []string{g.hostGateway}, nil
+	}
+	return g.getContainerIPAddresses(container, logger, true)
+}
+
+// isContainerHealthy checks docker health check status, unless the container opted out of it.
+// Containers without health check are considered healthy.
+func (g *CaddyfileGenerator) isContainerHealthy(container *types.Container) bool {
+	if _, ignoreHealth := container.Labels[g.options.IgnoreHealthLabel]; ignoreHealth && g.options.IgnoreHealthLabel != "" {
+		return true
+	}
+	// Container list only reports health in status, like: Up 5 seconds (health: starting)
+	return !strings.HasSuffix(container.Status, "(health: starting)") &&
+		!strings.HasSuffix(container.Status, "(unhealthy)")
 }
 
 func (g *CaddyfileGenerator) getContainerIPAddresses(container *types.Container, logger *zap.Logger, ingress bool) ([]string, error) {
@@ -19,7 +241,7 @@ func (g *CaddyfileGenerator) getContainerIPAddresses(container *types.Container,
 
 	for _, network := range container.NetworkSettings.Networks {
 		if !ingress || g.ingressNetworks[network.NetworkID] {
-			ips = append(ips, network.IPAddress)
+			ips = append(ips, g.selectIPAddresses([]string{network.IPAddress, network.GlobalIPv6Address})...)
 		}
 	}
 
@@ -30,3 +252,10 @@ func (g *CaddyfileGenerator) getContainerIPAddresses(container *types.Container,
 
 	return ips, nil
 }
+
+func getContainerName(container *types.Container) string {
+	if len(container.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
@@ -180,6 +180,105 @@ func TestContainers_Replicas(t *testing.T) {
 	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
 }
 
+func TestContainers_Health(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		{
+			ID:     "HEALTHY",
+			Status: "Up 5 minutes (healthy)",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.2",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "service.testdomain.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			},
+		},
+		{
+			ID:     "STARTING",
+			Status: "Up 1 second (health: starting)",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.3",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "service.testdomain.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			},
+		},
+		{
+			ID:     "UNHEALTHY",
+			Status: "Up 5 minutes (unhealthy)",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.4",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "service.testdomain.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			},
+		},
+		{
+			ID:     "IGNORE-HEALTH",
+			Status: "Up 5 minutes (unhealthy)",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.5",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "service.testdomain.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+				fmtLabel("%s_ignore_health"): "",
+			},
+		},
+		{
+			ID:     "NO-HEALTHCHECK",
+			Status: "Up 5 minutes",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.6",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "service.testdomain.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			},
+		},
+	}
+
+	const expectedCaddyfile = "service.testdomain.com {\n" +
+		"	reverse_proxy 172.17.0.2 172.17.0.5 172.17.0.6\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog +
+		`INFO	Skipping upstreams of container that is not healthy	{"container": "STARTING", "status": "Up 1 second (health: starting)"}` + newLine +
+		`INFO	Skipping upstreams of container that is not healthy	{"container": "UNHEALTHY", "status": "Up 5 minutes (unhealthy)"}` + newLine
+
+	testGeneration(t, dockerClient, func(options *config.Options) {
+		options.IgnoreHealthLabel = fmtLabel("%s_ignore_health")
+	}, expectedCaddyfile, expectedLogs)
+}
+
 func TestContainers_DoNotMergeDifferentProxies(t *testing.T) {
 	dockerClient := createBasicDockerClientMock()
 	dockerClient.ContainersData = []types.Container{
@@ -340,3 +439,65 @@ func TestContainers_WithSnippets(t *testing.T) {
 
 	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
 }
+
+func TestContainers_DialLabel(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		{
+			ID: "SOCKET-ID",
+			Labels: map[string]string{
+				fmtLabel("%s"):               "socket.example.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams http 8080}}",
+				fmtLabel("%s_dial"):          "unix//run/app.sock",
+			},
+		},
+		{
+			ID: "ADDRESS-ID",
+			Labels: map[string]string{
+				fmtLabel("%s"):               "address.example.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams https 8080}}",
+				fmtLabel("%s_dial"):          "10.0.0.2:8443, backend",
+			},
+		},
+	}
+
+	const expectedCaddyfile = "address.example.com {\n" +
+		"	reverse_proxy https://10.0.0.2:8443 https://backend:8080\n" +
+		"}\n" +
+		"socket.example.com {\n" +
+		"	reverse_proxy unix//run/app.sock\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, func(options *config.Options) {
+		options.DialLabel = fmtLabel("%s_dial")
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestContainers_HostNetwork(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainerInspectData[caddyContainerID].NetworkSettings.Networks["overlay"].Gateway = "172.18.0.1"
+	container := types.Container{
+		ID: "CONTAINER-ID",
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"host": {},
+			},
+		},
+		Labels: map[string]string{
+			fmtLabel("%s"):               "host.example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams 8080}}",
+		},
+	}
+	container.HostConfig.NetworkMode = "host"
+	dockerClient.ContainersData = []types.Container{container}
+
+	const expectedCaddyfile = "host.example.com {\n" +
+		"	reverse_proxy 172.18.0.1:8080\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+}
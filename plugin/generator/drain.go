@@ -0,0 +1,305 @@
+package generator
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+	"go.uber.org/zap"
+)
+
+// drainingMatcher never matches requests. Draining upstreams are moved to a reverse_proxy using it,
+// so that servers stop load balancing new requests to them, but keep tracking their in-flight requests.
+const drainingMatcher = "@caddy_docker_proxy_draining"
+
+// drain keeps a stopping container in upstreams until its in-flight requests finish
+type drain struct {
+	since     time.Time
+	done      bool
+	ended     time.Time
+	container *types.Container
+	upstreams []string
+}
+
+// DrainingContainer is a container kept in upstreams while it drains.
+// Upstreams are the addresses caddy dials, like IPs, dial label addresses or the host gateway.
+type DrainingContainer struct {
+	ID        string
+	Since     time.Time
+	Upstreams []string
+}
+
+// Drain starts draining a container, keeping it in upstreams after docker stops listing it.
+// It is ignored when drain-timeout is not set.
+func (g *CaddyfileGenerator) Drain(id string) {
+	if g.options.DrainTimeout <= 0 {
+		return
+	}
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	if _, exists := g.drains[id]; !exists {
+		g.drains[id] = &drain{since: time.Now()}
+	}
+}
+
+// EndDrain removes a drained container from upstreams on the next generation
+func (g *CaddyfileGenerator) EndDrain(id string) {
+	g.drainsMutex.Lock()
+	if drain, exists := g.drains[id]; exists && !drain.done {
+		drain.done = true
+		drain.ended = time.Now()
+	}
+	g.drainsMutex.Unlock()
+	g.Invalidate("container", id)
+}
+
+// CancelDrain forgets the drain of a container, adding it back to upstreams if it was drained
+func (g *CaddyfileGenerator) CancelDrain(id string) {
+	g.drainsMutex.Lock()
+	_, exists := g.drains[id]
+	delete(g.drains, id)
+	g.drainsMutex.Unlock()
+	if exists {
+		g.Invalidate("container", id)
+	}
+}
+
+// clearEndedDrain forgets the drain of a listed container that is still running a drain timeout after it drained.
+// It wasn't stopping, like containers restarted or killed with signals that don't stop them.
+func (g *CaddyfileGenerator) clearEndedDrain(id string) {
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	if drain, exists := g.drains[id]; exists && drain.done && time.Since(drain.ended) >= g.options.DrainTimeout {
+		delete(g.drains, id)
+	}
+}
+
+// Draining returns containers that are still draining
+func (g *CaddyfileGenerator) Draining() []DrainingContainer {
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	draining := []DrainingContainer{}
+	for id, drain := range g.drains {
+		// Containers that weren't listed yet don't have upstreams to check
+		if !drain.done && drain.container != nil {
+			draining = append(draining, DrainingContainer{
+				ID:        id,
+				Since:     drain.since,
+				Upstreams: append([]string{}, drain.upstreams...),
+			})
+		}
+	}
+	return draining
+}
+
+// isDrained checks if a container finished draining, and must be left out of upstreams
+func (g *CaddyfileGenerator) isDrained(id string) bool {
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	drain, exists := g.drains[id]
+	return exists && drain.done
+}
+
+// updateDrain keeps the last listing and upstreams of a draining container
+func (g *CaddyfileGenerator) updateDrain(container *types.Container, containerCaddyfile *caddyfile.Container, logger *zap.Logger) {
+	if !g.isDraining(container.ID) {
+		return
+	}
+	// Upstreams are resolved like in templates, which check drains as well
+	upstreams, err := g.getContainerUpstreams(container, logger)
+	if err != nil {
+		logger.Error("Failed to get upstreams of draining container", zap.String("container", container.ID), zap.Error(err))
+	}
+	if container.HostConfig.NetworkMode == "host" && containerCaddyfile != nil {
+		upstreams = getHostNetworkUpstreams(upstreams, containerCaddyfile)
+	}
+
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	if drain, exists := g.drains[container.ID]; exists && !drain.done {
+		// Keep a copy, listings are reused by callers
+		containerCopy := *container
+		drain.container = &containerCopy
+		drain.upstreams = upstreams
+	}
+}
+
+// getHostNetworkUpstreams narrows the host gateway upstream of a host network container to the addresses its caddyfile proxies to.
+// Host network containers share the gateway address, only ports tell them apart.
+func getHostNetworkUpstreams(upstreams []string, containerCaddyfile *caddyfile.Container) []string {
+	narrowed := []string{}
+	for _, upstream := range upstreams {
+		found := false
+		for _, site := range containerCaddyfile.Children {
+			for _, proxied := range getReverseProxyUpstreams(site) {
+				if index := strings.Index(proxied, "://"); index >= 0 {
+					proxied = proxied[index+3:]
+				}
+				host, _, err := net.SplitHostPort(proxied)
+				if err == nil && host == upstream && !containsString(narrowed, proxied) {
+					narrowed = append(narrowed, proxied)
+					found = true
+				}
+			}
+		}
+		if !found {
+			narrowed = append(narrowed, upstream)
+		}
+	}
+	return narrowed
+}
+
+// isDraining checks if a container is draining, and must be kept in upstreams
+func (g *CaddyfileGenerator) isDraining(id string) bool {
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	drain, exists := g.drains[id]
+	return exists && !drain.done
+}
+
+// unlistedDrains returns draining containers docker doesn't list anymore,
+// forgetting the ones that finished draining or were never listed
+func (g *CaddyfileGenerator) unlistedDrains(listed map[string]bool) []*types.Container {
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	containers := []*types.Container{}
+	for id := range g.drains {
+		if listed[id] {
+			continue
+		}
+		if container := g.unlistedDrain(id); container != nil {
+			containers = append(containers, container)
+		}
+	}
+	return containers
+}
+
+// withUnlistedDraining returns listed containers followed by draining containers docker doesn't list anymore,
+// for templates gathering upstreams of other containers
+func (g *CaddyfileGenerator) withUnlistedDraining(listed map[string]types.Container) []*types.Container {
+	containers := []*types.Container{}
+	for id := range listed {
+		container := listed[id]
+		containers = append(containers, &container)
+	}
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	for id, drain := range g.drains {
+		if _, isListed := listed[id]; !isListed && !drain.done && drain.container != nil {
+			container := *drain.container
+			containers = append(containers, &container)
+		}
+	}
+	return containers
+}
+
+// getUnlistedDrain is like unlistedDrains for a single container
+func (g *CaddyfileGenerator) getUnlistedDrain(id string) *types.Container {
+	g.drainsMutex.Lock()
+	defer g.drainsMutex.Unlock()
+	return g.unlistedDrain(id)
+}
+
+// unlistedDrain is like getUnlistedDrain, it requires drainsMutex
+func (g *CaddyfileGenerator) unlistedDrain(id string) *types.Container {
+	drain, exists := g.drains[id]
+	if !exists {
+		return nil
+	}
+	if drain.done || drain.container == nil {
+		delete(g.drains, id)
+		return nil
+	}
+	return drain.container
+}
+
+// separateDraining moves upstreams of draining containers to reverse_proxy directives that don't match any request
+func (g *CaddyfileGenerator) separateDraining(container *caddyfile.Container) {
+	targets := []string{}
+	for _, draining := range g.Draining() {
+		targets = append(targets, draining.Upstreams...)
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	for _, site := range container.Children {
+		if site.IsGlobalBlock() || site.IsSnippet() || site.IsMatcher() {
+			continue
+		}
+		if separateDrainingUpstreams(site, targets) {
+			matcher := caddyfile.CreateBlock()
+			matcher.AddKeys(drainingMatcher, "not", "path", "*")
+			site.AddBlock(matcher)
+		}
+	}
+}
+
+// separateDrainingUpstreams separates draining upstreams of reverse_proxy directives of a block and its descendants,
+// returning if any was separated. Directives where all upstreams are draining keep them, there is nowhere else to send requests.
+func separateDrainingUpstreams(block *caddyfile.Block, targets []string) bool {
+	separated := false
+	for _, child := range append([]*caddyfile.Block{}, block.Children...) {
+		if child.GetFirstKey() != "reverse_proxy" {
+			separated = separateDrainingUpstreams(child, targets) || separated
+			continue
+		}
+		keys := []string{"reverse_proxy"}
+		if getReverseProxyMatcher(child) != "" {
+			keys = append(keys, child.Keys[1])
+		}
+		active, draining := []string{}, []string{}
+		for _, upstream := range child.Keys[len(keys):] {
+			if !isDrainingUpstream(upstream, targets) {
+				active = append(active, upstream)
+			} else if !containsString(draining, upstream) {
+				draining = append(draining, upstream)
+			}
+		}
+		if len(active) == 0 || len(draining) == 0 {
+			continue
+		}
+		child.Keys = append(keys, active...)
+
+		drainingBlock := caddyfile.CreateBlock()
+		drainingBlock.Order = child.Order
+		drainingBlock.AddKeys("reverse_proxy", drainingMatcher)
+		drainingBlock.AddKeys(draining...)
+		for _, provenance := range child.Provenance {
+			provenanceCopy := *provenance
+			drainingBlock.AddProvenance(&provenanceCopy)
+		}
+		block.AddBlock(drainingBlock)
+		separated = true
+	}
+	return separated
+}
+
+// isDrainingUpstream checks if a reverse_proxy upstream, like http://10.0.0.2:8080, dials one of the draining targets.
+// Targets without port match any port.
+func isDrainingUpstream(upstream string, targets []string) bool {
+	if index := strings.Index(upstream, "://"); index >= 0 {
+		upstream = upstream[index+3:]
+	}
+	host, _, err := net.SplitHostPort(upstream)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(upstream, "["), "]")
+	}
+	for _, target := range targets {
+		if target == upstream || target == host {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/docker"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func createDrainContainer(id string, ip string, labels map[string]string) types.Container {
+	container := createNetworkContainer(id, "", ip, labels)
+	container.Labels[fmtLabel("%s")] = "service.testdomain.com"
+	container.Labels[fmtLabel("%s.reverse_proxy")] = "{{upstreams}}"
+	return container
+}
+
+func createDrainGenerator(dockerClient *docker.ClientMock) *CaddyfileGenerator {
+	return CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix:  DefaultLabelPrefix,
+		DrainLabel:   fmtLabel("%s_drain"),
+		DrainTimeout: time.Minute,
+	})
+}
+
+func TestDrain_StoppedContainer(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{}),
+		createDrainContainer("B", "172.17.0.3", map[string]string{}),
+	}
+	generator := createDrainGenerator(dockerClient)
+	generator.Generate(zap.NewNop())
+
+	// Killed container stops receiving new requests, but is kept after docker stops listing it
+	generator.Drain("A")
+	generator.Invalidate("container", "A")
+	generator.GenerateIncremental(zap.NewNop())
+	dockerClient.ContainersData = dockerClient.ContainersData[1:]
+	generator.Invalidate("container", "A")
+
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 172.17.0.2\n"+
+		"}\n", string(generation.Caddyfile))
+
+	draining := generator.Draining()
+	assert.Len(t, draining, 1)
+	assert.Equal(t, "A", draining[0].ID)
+	assert.Equal(t, []string{"172.17.0.2"}, draining[0].Upstreams)
+
+	// Full listings keep it as well, generating the same caddyfile
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 172.17.0.2\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Drained container is removed
+	generator.EndDrain("A")
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+	assert.Empty(t, generator.Draining())
+}
+
+func TestDrain_Label(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{
+			fmtLabel("%s_drain"): "",
+		}),
+		createDrainContainer("B", "172.17.0.3", map[string]string{}),
+	}
+	generator := createDrainGenerator(dockerClient)
+
+	generation := generator.Generate(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 172.17.0.2\n"+
+		"}\n", string(generation.Caddyfile))
+	assert.Len(t, generator.Draining(), 1)
+
+	// Drained container is left out of upstreams while it is running
+	generator.EndDrain("A")
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+}
+
+func TestDrain_DialLabel(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{
+			fmtLabel("%s_dial"): "10.0.0.5:8080",
+		}),
+		createDrainContainer("B", "172.17.0.3", map[string]string{}),
+	}
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix:  DefaultLabelPrefix,
+		DialLabel:    fmtLabel("%s_dial"),
+		DrainTimeout: time.Minute,
+	})
+	generator.Generate(zap.NewNop())
+
+	// Draining upstreams are the dialed addresses, not the container IPs
+	generator.Drain("A")
+	generator.Invalidate("container", "A")
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 10.0.0.5:8080\n"+
+		"}\n", string(generation.Caddyfile))
+
+	draining := generator.Draining()
+	assert.Len(t, draining, 1)
+	assert.Equal(t, []string{"10.0.0.5:8080"}, draining[0].Upstreams)
+}
+
+func TestDrain_HostNetwork(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainerInspectData[caddyContainerID].NetworkSettings.Networks["overlay"].Gateway = "172.18.0.1"
+	createHostContainer := func(id string, port string) types.Container {
+		container := createNetworkContainer(id, "", "", map[string]string{
+			fmtLabel("%s"):               "service.testdomain.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams " + port + "}}",
+		})
+		container.NetworkSettings.Networks = map[string]*network.EndpointSettings{"host": {}}
+		container.HostConfig.NetworkMode = "host"
+		return container
+	}
+	dockerClient.ContainersData = []types.Container{
+		createHostContainer("A", "8080"),
+		createHostContainer("B", "9090"),
+	}
+	generator := createDrainGenerator(dockerClient)
+	generator.Generate(zap.NewNop())
+
+	// Host network containers share the gateway address, only the draining port is separated
+	generator.Drain("A")
+	generator.Invalidate("container", "A")
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.18.0.1:9090\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 172.18.0.1:8080\n"+
+		"}\n", string(generation.Caddyfile))
+
+	draining := generator.Draining()
+	assert.Len(t, draining, 1)
+	assert.Equal(t, []string{"172.18.0.1:8080"}, draining[0].Upstreams)
+}
+
+func TestDrain_AllUpstreams(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{}),
+	}
+	generator := createDrainGenerator(dockerClient)
+	generator.Generate(zap.NewNop())
+
+	// Draining upstreams keep receiving requests when there is nowhere else to send them
+	generator.Drain("A")
+	generator.Invalidate("container", "A")
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.2\n"+
+		"}\n", string(generation.Caddyfile))
+}
+
+func TestDrain_Cancel(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{}),
+		createDrainContainer("B", "172.17.0.3", map[string]string{}),
+	}
+	generator := createDrainGenerator(dockerClient)
+	generator.Generate(zap.NewNop())
+
+	// Restarted container is added back to upstreams
+	generator.Drain("A")
+	generator.EndDrain("A")
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+
+	generator.CancelDrain("A")
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.2 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+	assert.Empty(t, generator.Draining())
+}
+
+func TestDrain_EndedStillListed(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{}),
+		createDrainContainer("B", "172.17.0.3", map[string]string{}),
+	}
+	generator := createDrainGenerator(dockerClient)
+	generator.Generate(zap.NewNop())
+
+	// Drained container is left out while it may still be stopping
+	generator.Drain("A")
+	generator.EndDrain("A")
+	generation := generator.Generate(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Container still listed a drain timeout later wasn't stopping
+	generator.drains["A"].ended = time.Now().Add(-time.Minute)
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, "service.testdomain.com {\n"+
+		"	reverse_proxy 172.17.0.2 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+}
+
+func TestDrain_Disabled(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createDrainContainer("A", "172.17.0.2", map[string]string{}),
+	}
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	})
+	generator.Generate(zap.NewNop())
+
+	generator.Drain("A")
+	dockerClient.ContainersData = []types.Container{}
+	generator.Invalidate("container", "A")
+
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "# Empty caddyfile", string(generation.Caddyfile))
+}
@@ -1,12 +1,14 @@
 package generator
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -30,30 +32,127 @@ type CaddyfileGenerator struct {
 	dockerClient         docker.Client
 	dockerUtils          docker.Utils
 	ingressNetworks      map[string]bool
+	hostGateway          string
 	swarmIsAvailable     bool
 	swarmIsAvailableTime time.Time
+	sources              []Source
+	containers           *containersSource
+	services             *servicesSource
+	changesMutex         sync.Mutex
+	generateMutex        sync.Mutex
+	changes              map[string][]string
+	drainsMutex          sync.Mutex
+	drains               map[string]*drain
 }
 
 // CreateGenerator creates a new generator
 func CreateGenerator(dockerClient docker.Client, dockerUtils docker.Utils, options *config.Options) *CaddyfileGenerator {
-	var labelRegexString = fmt.Sprintf("^%s(_\\d+)?(\\.|$)", options.LabelPrefix)
-
-	return &CaddyfileGenerator{
+	g := &CaddyfileGenerator{
 		options:      options,
-		labelRegex:   regexp.MustCompile(labelRegexString),
+		labelRegex:   createLabelRegex(options.LabelPrefix),
 		dockerClient: dockerClient,
 		dockerUtils:  dockerUtils,
+		drains:       map[string]*drain{},
+	}
+
+	g.containers = &containersSource{g, newResourceCache(), map[string]types.Container{}}
+	g.services = &servicesSource{g, newResourceCache(), map[string]swarm.Service{}}
+
+	g.AddSource(&configsSource{g, newResourceCache()})
+	g.AddSource(g.containers)
+	g.AddSource(g.services)
+
+	if options.StaticContainersPath != "" {
+		g.AddSource(&staticSource{g})
 	}
+
+	return g
+}
+
+// AddSource adds a source of caddyfile fragments, collected after the existing ones
+func (g *CaddyfileGenerator) AddSource(source Source) {
+	g.sources = append(g.sources, source)
+}
+
+// Generation is the result of generating a caddyfile from docker metadata
+type Generation struct {
+	Caddyfile         []byte
+	ControlledServers []string
+	Contributions     []Contribution
+	ProcessLogs       []byte
+	RemovedBlocks     []RemovedBlock
+}
+
+// RemovedBlock describes a block removed while processing the caddyfile and where it was generated from.
+// Labels that couldn't be converted into a caddyfile are reported without a block.
+type RemovedBlock struct {
+	Block      string                  `json:"block"`
+	Error      string                  `json:"error"`
+	Provenance []*caddyfile.Provenance `json:"provenance"`
+}
+
+// Contribution describes the caddyfile blocks generated from a docker resource
+type Contribution struct {
+	Kind   string   `json:"kind"`
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Blocks []string `json:"blocks"`
 }
 
 // GenerateCaddyfile generates a caddy file config from docker metadata
 func (g *CaddyfileGenerator) GenerateCaddyfile(logger *zap.Logger) ([]byte, []string) {
-	var caddyfileBuffer bytes.Buffer
+	generation := g.Generate(logger)
+	return generation.Caddyfile, generation.ControlledServers
+}
+
+// Invalidate marks a docker resource as changed, to be refreshed by the next incremental generation
+func (g *CaddyfileGenerator) Invalidate(kind string, id string) {
+	g.changesMutex.Lock()
+	defer g.changesMutex.Unlock()
+	if g.changes == nil {
+		g.changes = map[string][]string{}
+	}
+	for _, changedID := range g.changes[kind] {
+		if changedID == id {
+			return
+		}
+	}
+	g.changes[kind] = append(g.changes[kind], id)
+}
+
+// takeChanges returns and clears resources invalidated since the last generation
+func (g *CaddyfileGenerator) takeChanges() map[string][]string {
+	g.changesMutex.Lock()
+	defer g.changesMutex.Unlock()
+	changes := g.changes
+	g.changes = nil
+	return changes
+}
+
+// Generate generates a caddy file config from all sources, keeping track of which resources contributed to it.
+// It lists all docker resources again, reconciling what was cached by incremental generations.
+func (g *CaddyfileGenerator) Generate(logger *zap.Logger) *Generation {
+	return g.generate(logger, true)
+}
+
+// GenerateIncremental generates a caddy file config like Generate,
+// only inspecting docker resources invalidated since the last generation
+func (g *CaddyfileGenerator) GenerateIncremental(logger *zap.Logger) *Generation {
+	return g.generate(logger, false)
+}
+
+func (g *CaddyfileGenerator) generate(logger *zap.Logger, full bool) *Generation {
+	// Sources keep the state of the last generation, generations can't run concurrently
+	g.generateMutex.Lock()
+	defer g.generateMutex.Unlock()
+
+	changes := g.takeChanges()
 
 	if g.ingressNetworks == nil {
-		ingressNetworks, err := g.getIngressNetworks(logger)
+		ingressNetworks, hostGateway, err := g.getIngressNetworks(logger)
 		if err == nil {
 			g.ingressNetworks = ingressNetworks
+			g.hostGateway = hostGateway
 		} else {
 			logger.Error("Failed to get ingress networks", zap.Error(err))
 		}
@@ -66,6 +165,7 @@ func (g *CaddyfileGenerator) GenerateCaddyfile(logger *zap.Logger) ([]byte, []st
 
 	caddyfileBlock := caddyfile.CreateContainer()
 	controlledServers := []string{}
+	contributions := []Contribution{}
 
 	// Add caddyfile from path
 	if g.options.CaddyfilePath != "" {
@@ -77,6 +177,7 @@ func (g *CaddyfileGenerator) GenerateCaddyfile(logger *zap.Logger) ([]byte, []st
 			if err != nil {
 				logger.Error("Failed to parse Caddyfile", zap.String("path", g.options.CaddyfilePath), zap.Error(err))
 			} else {
+				block.SetProvenance("caddyfile", "", g.options.CaddyfilePath)
 				caddyfileBlock.Merge(block)
 			}
 		}
@@ -84,119 +185,77 @@ func (g *CaddyfileGenerator) GenerateCaddyfile(logger *zap.Logger) ([]byte, []st
 		logger.Info("Skipping default Caddyfile because no path is set")
 	}
 
-	// Add Caddyfile from swarm configs
-	if g.swarmIsAvailable {
-		configs, err := g.dockerClient.ConfigList(context.Background(), types.ConfigListOptions{})
-		if err == nil {
-			for _, config := range configs {
-				if _, hasLabel := config.Spec.Labels[g.options.LabelPrefix]; hasLabel {
-					fullConfig, _, err := g.dockerClient.ConfigInspectWithRaw(context.Background(), config.ID)
-					if err != nil {
-						logger.Error("Failed to inspect Swarm Config", zap.String("config", config.Spec.Name), zap.Error(err))
-
-					} else {
-						block, err := caddyfile.Unmarshal(fullConfig.Spec.Data)
-						if err != nil {
-							logger.Error("Failed to parse Swarm Config caddyfile format", zap.String("config", config.Spec.Name), zap.Error(err))
-						} else {
-							caddyfileBlock.Merge(block)
-						}
-					}
-				}
-			}
+	// Collect caddyfiles from sources
+	sourcesFragments := make([][]*Fragment, len(g.sources))
+	sourcesControlledServers := make([][]string, len(g.sources))
+	for i, source := range g.sources {
+		if incrementalSource, isIncremental := source.(IncrementalSource); isIncremental && !full {
+			sourcesFragments[i], sourcesControlledServers[i] = incrementalSource.CollectChanged(logger, changes[incrementalSource.Kind()])
 		} else {
-			logger.Error("Failed to get Swarm configs", zap.Error(err))
+			sourcesFragments[i], sourcesControlledServers[i] = source.Collect(logger)
 		}
-	} else {
-		logger.Info("Skipping swarm config caddyfiles because swarm is not available")
 	}
 
-	// Add containers
-	containers, err := g.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
-	if err == nil {
-		for _, container := range containers {
-			if _, isControlledServer := container.Labels[g.options.ControlledServersLabel]; isControlledServer {
-				ips, err := g.getContainerIPAddresses(&container, logger, false)
-				if err != nil {
-					logger.Error("Failed to get Container IPs", zap.String("container", container.ID), zap.Error(err))
-				} else {
-					for _, ip := range ips {
-						if g.options.ControllerNetwork == nil || g.options.ControllerNetwork.Contains(net.ParseIP(ip)) {
-							controlledServers = append(controlledServers, ip)
-						}
-					}
-				}
-			}
-
-			containerCaddyfile, err := g.getContainerCaddyfile(&container, logger)
-			if err == nil {
-				caddyfileBlock.Merge(containerCaddyfile)
-			} else {
-				logger.Error("Failed to get Container Caddyfile", zap.String("container", container.ID), zap.Error(err))
+	// Refresh caddyfiles referencing other resources, now that all sources are up to date
+	if full || len(changes) > 0 {
+		for i, source := range g.sources {
+			if incrementalSource, isIncremental := source.(IncrementalSource); isIncremental {
+				sourcesFragments[i], sourcesControlledServers[i] = incrementalSource.CollectDynamic(logger)
 			}
 		}
-	} else {
-		logger.Error("Failed to get ContainerList", zap.Error(err))
 	}
 
-	// Add services
-	if g.swarmIsAvailable {
-		services, err := g.dockerClient.ServiceList(context.Background(), types.ServiceListOptions{})
-		if err == nil {
-			for _, service := range services {
-				logger.Debug("Swarm service", zap.String("service", service.Spec.Name))
-
-				if _, isControlledServer := service.Spec.Labels[g.options.ControlledServersLabel]; isControlledServer {
-					ips, err := g.getServiceTasksIps(&service, logger, false)
-					if err != nil {
-						logger.Error("Failed to  get Swarm service IPs", zap.String("service", service.Spec.Name), zap.Error(err))
-					} else {
-						for _, ip := range ips {
-							if g.options.ControllerNetwork == nil || g.options.ControllerNetwork.Contains(net.ParseIP(ip)) {
-								controlledServers = append(controlledServers, ip)
-							}
-						}
-					}
-				}
-
-				// caddy. labels based config
-				serviceCaddyfile, err := g.getServiceCaddyfile(&service, logger)
-				if err == nil {
-					caddyfileBlock.Merge(serviceCaddyfile)
-				} else {
-					logger.Error("Failed to get Swarm service caddyfile", zap.String("service", service.Spec.Name), zap.Error(err))
-				}
+	// Add caddyfiles from sources
+	removedBlocks := []RemovedBlock{}
+	siteCanaries := canaries{}
+	for i, fragments := range sourcesFragments {
+		controlledServers = append(controlledServers, sourcesControlledServers[i]...)
+		for _, fragment := range fragments {
+			if fragment.Err != nil {
+				removedBlocks = append(removedBlocks, getFragmentError(fragment))
+				continue
 			}
-		} else {
-			logger.Error("Failed to get Swarm services", zap.Error(err))
-		}
-	} else {
-		logger.Info("Skipping swarm services because swarm is not available")
-	}
-
-	// Write global blocks first
-	globalCaddyfile := caddyfile.CreateContainer()
-	for _, block := range caddyfileBlock.Children {
-		if block.IsGlobalBlock() {
-			globalCaddyfile.AddBlock(block)
-			caddyfileBlock.Remove(block)
+			// Merging modifies blocks, keep the ones cached by sources intact
+			fragmentCaddyfile := fragment.Caddyfile.Clone()
+			fragmentCaddyfile.SetProvenance(fragment.Kind, fragment.ID, fragment.Name)
+			weights, canaryRemovedBlocks := extractCanary(fragmentCaddyfile)
+			for _, removedBlock := range canaryRemovedBlocks {
+				logger.Error("Invalid canary", zap.String("kind", fragment.Kind), zap.String("name", fragment.Name), zap.String("error", removedBlock.Error))
+			}
+			removedBlocks = append(removedBlocks, canaryRemovedBlocks...)
+			siteCanaries.add(fragmentCaddyfile, weights)
+			contributions = appendContribution(contributions, fragment)
+			caddyfileBlock.Merge(fragmentCaddyfile)
 		}
 	}
-	caddyfileBuffer.Write(globalCaddyfile.Marshal())
-
-	// Write remaining blocks
-	caddyfileBuffer.Write(caddyfileBlock.Marshal())
+	siteCanaries.apply(caddyfileBlock)
+	g.separateDraining(caddyfileBlock)
 
-	caddyfileContent := caddyfileBuffer.Bytes()
+	var processLogs []byte
 
 	if g.options.ProcessCaddyfile {
-		processCaddyfileContent, processLogs := caddyfile.Process(caddyfileContent)
-		caddyfileContent = processCaddyfileContent
-		if len(processLogs) > 0 {
-			logger.Info("Process Caddyfile", zap.ByteString("logs", processLogs))
+		processResult := caddyfileBlock.Process()
+		caddyfileBlock = processResult.Container
+		processLogs = processResult.Logs
+		for _, removedBlock := range processResult.RemovedBlocks {
+			removedBlocks = append(removedBlocks, RemovedBlock{
+				Block:      string(removedBlock.Block.Marshal()),
+				Error:      removedBlock.Err.Error(),
+				Provenance: removedBlock.Block.GetAllProvenance(),
+			})
+		}
+		if len(processResult.Logs) > 0 {
+			logger.Info("Process Caddyfile", zap.ByteString("logs", processResult.Logs))
 		}
 	}
 
+	var caddyfileContent []byte
+	if g.options.ProvenanceComments {
+		caddyfileContent = caddyfileBlock.MarshalWithProvenance()
+	} else {
+		caddyfileContent = caddyfileBlock.Marshal()
+	}
+
 	if len(caddyfileContent) == 0 {
 		caddyfileContent = []byte("# Empty caddyfile")
 	}
@@ -205,7 +264,52 @@ func (g *CaddyfileGenerator) GenerateCaddyfile(logger *zap.Logger) ([]byte, []st
 		controlledServers = append(controlledServers, "localhost")
 	}
 
-	return caddyfileContent, controlledServers
+	return &Generation{
+		Caddyfile:         caddyfileContent,
+		ControlledServers: controlledServers,
+		Contributions:     contributions,
+		ProcessLogs:       processLogs,
+		RemovedBlocks:     removedBlocks,
+	}
+}
+
+// getFragmentError describes labels of a resource that couldn't be converted into a caddyfile, like a removed block
+func getFragmentError(fragment *Fragment) RemovedBlock {
+	provenance := &caddyfile.Provenance{
+		Kind: fragment.Kind,
+		ID:   fragment.ID,
+		Name: fragment.Name,
+	}
+	var labelError *caddyfile.LabelError
+	if errors.As(fragment.Err, &labelError) {
+		provenance.Label = labelError.Label
+	}
+	return RemovedBlock{
+		Error:      fragment.Err.Error(),
+		Provenance: []*caddyfile.Provenance{provenance},
+	}
+}
+
+// appendContribution records the top level blocks generated from a resource
+func appendContribution(contributions []Contribution, fragment *Fragment) []Contribution {
+	if len(fragment.Caddyfile.Children) == 0 {
+		return contributions
+	}
+	blocks := []string{}
+	for _, block := range fragment.Caddyfile.Children {
+		if block.IsGlobalBlock() {
+			blocks = append(blocks, "{}")
+		} else {
+			blocks = append(blocks, strings.Join(block.Keys, " "))
+		}
+	}
+	sort.Strings(blocks)
+	return append(contributions, Contribution{
+		Kind:   fragment.Kind,
+		ID:     fragment.ID,
+		Name:   fragment.Name,
+		Blocks: blocks,
+	})
 }
 
 func (g *CaddyfileGenerator) checkSwarmAvailability(logger *zap.Logger, isFirstCheck bool) {
@@ -222,13 +326,16 @@ func (g *CaddyfileGenerator) checkSwarmAvailability(logger *zap.Logger, isFirstC
 	}
 }
 
-func (g *CaddyfileGenerator) getIngressNetworks(logger *zap.Logger) (map[string]bool, error) {
+// getIngressNetworks gets IDs of ingress networks,
+// and the address of the docker host in them, used to reach containers on the host network
+func (g *CaddyfileGenerator) getIngressNetworks(logger *zap.Logger) (map[string]bool, string, error) {
 	ingressNetworks := map[string]bool{}
+	gateways := []string{}
 
 	if len(g.options.IngressNetworks) > 0 {
 		networks, err := g.dockerClient.NetworkList(context.Background(), types.NetworkListOptions{})
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		for _, dockerNetwork := range networks {
 			if dockerNetwork.Ingress {
@@ -237,35 +344,55 @@ func (g *CaddyfileGenerator) getIngressNetworks(logger *zap.Logger) (map[string]
 			for _, ingressNetwork := range g.options.IngressNetworks {
 				if dockerNetwork.Name == ingressNetwork {
 					ingressNetworks[dockerNetwork.ID] = true
+					for _, ipamConfig := range dockerNetwork.IPAM.Config {
+						gateways = append(gateways, ipamConfig.Gateway)
+					}
 				}
 			}
 		}
 	} else {
 		containerID, err := g.dockerUtils.GetCurrentContainerID()
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		logger.Info("Caddy ContainerID", zap.String("ID", containerID))
 		container, err := g.dockerClient.ContainerInspect(context.Background(), containerID)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		for _, network := range container.NetworkSettings.Networks {
 			networkInfo, err := g.dockerClient.NetworkInspect(context.Background(), network.NetworkID, types.NetworkInspectOptions{})
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 			if networkInfo.Ingress {
 				continue
 			}
 			ingressNetworks[network.NetworkID] = true
+			gateways = append(gateways, network.Gateway, network.IPv6Gateway)
+		}
+
+		// Caddy on the host network reaches other containers on the host network locally
+		if container.ContainerJSONBase != nil && container.HostConfig != nil && container.HostConfig.NetworkMode.IsHost() {
+			gateways = []string{"127.0.0.1"}
 		}
 	}
 
 	logger.Info("IngressNetworksMap", zap.String("ingres", fmt.Sprintf("%v", ingressNetworks)))
 
-	return ingressNetworks, nil
+	hostGateway := ""
+	if selected := g.selectIPAddresses(gateways); len(selected) > 0 {
+		sort.Strings(selected)
+		hostGateway = selected[0]
+	}
+
+	return ingressNetworks, hostGateway, nil
+}
+
+// createLabelRegex matches labels with the prefix, optionally followed by an index, like caddy_1.reverse_proxy
+func createLabelRegex(labelPrefix string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("^%s(_\\d+)?(\\.|$)", labelPrefix))
 }
 
 func (g *CaddyfileGenerator) filterLabels(labels map[string]string) map[string]string {
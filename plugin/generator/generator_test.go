@@ -11,6 +11,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/swarm"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
 	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
 	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/docker"
 	"github.com/stretchr/testify/assert"
@@ -126,6 +127,274 @@ func TestIgnoreLabelsWithoutCaddyPrefix(t *testing.T) {
 	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
 }
 
+func TestContributions(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		{
+			ID: "CONTAINER-ID",
+			Names: []string{
+				"/container-name",
+			},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.2",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s_0"):               "a.example.com",
+				fmtLabel("%s_0.reverse_proxy"): "{{upstreams}}",
+				fmtLabel("%s_1"):               "b.example.com",
+				fmtLabel("%s_1.reverse_proxy"): "{{upstreams}}",
+			},
+		},
+		{
+			ID: "UNLABELED-CONTAINER-ID",
+		},
+	}
+	dockerClient.ServicesData = []swarm.Service{
+		{
+			ID: "SERVICE-ID",
+			Spec: swarm.ServiceSpec{
+				Annotations: swarm.Annotations{
+					Name: "service",
+					Labels: map[string]string{
+						fmtLabel("%s"):               "a.example.com",
+						fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+					},
+				},
+			},
+		},
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	})
+
+	generation := generator.Generate(zap.NewNop())
+
+	assert.Equal(t, []Contribution{
+		{
+			Kind:   "container",
+			ID:     "CONTAINER-ID",
+			Name:   "container-name",
+			Blocks: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			Kind:   "service",
+			ID:     "SERVICE-ID",
+			Name:   "service",
+			Blocks: []string{"a.example.com"},
+		},
+	}, generation.Contributions)
+}
+
+func TestProvenance(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		{
+			ID: "CONTAINER-ID",
+			Names: []string{
+				"/container-name",
+			},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: "172.17.0.2",
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s_0"):               "a.example.com",
+				fmtLabel("%s_0.reverse_proxy"): "{{upstreams}}",
+				fmtLabel("%s_1"):               "b.example.com",
+				fmtLabel("%s_1.invalid"):       "",
+			},
+		},
+	}
+	dockerClient.ServicesData = []swarm.Service{
+		{
+			ID: "SERVICE-ID",
+			Spec: swarm.ServiceSpec{
+				Annotations: swarm.Annotations{
+					Name: "service",
+					Labels: map[string]string{
+						fmtLabel("%s"):        "a.example.com",
+						fmtLabel("%s.encode"): "gzip",
+					},
+				},
+			},
+		},
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix:        DefaultLabelPrefix,
+		ProcessCaddyfile:   true,
+		ProvenanceComments: true,
+	})
+
+	generation := generator.Generate(zap.NewNop())
+
+	const expectedCaddyfile = "# container container-name label caddy_0\n" +
+		"# service service label caddy\n" +
+		"a.example.com {\n" +
+		"	# service service label caddy.encode\n" +
+		"	encode gzip\n" +
+		"	# container container-name label caddy_0.reverse_proxy\n" +
+		"	reverse_proxy 172.17.0.2\n" +
+		"}\n"
+
+	const expectedLogs = "[ERROR]  Removing invalid block: Caddyfile:6: unrecognized directive: invalid\n" +
+		"# container container-name label caddy_1\n" +
+		"b.example.com {\n" +
+		"	# container container-name label caddy_1.invalid\n" +
+		"	invalid\n" +
+		"}\n" +
+		"\n"
+
+	assert.Equal(t, expectedCaddyfile, string(generation.Caddyfile))
+	assert.Equal(t, expectedLogs, string(generation.ProcessLogs))
+	assert.Equal(t, []RemovedBlock{
+		{
+			Block: "b.example.com {\n\tinvalid\n}\n",
+			Error: "Caddyfile:6: unrecognized directive: invalid",
+			Provenance: []*caddyfile.Provenance{
+				{Kind: "container", ID: "CONTAINER-ID", Name: "container-name", Label: "caddy_1"},
+				{Kind: "container", ID: "CONTAINER-ID", Name: "container-name", Label: "caddy_1.invalid"},
+			},
+		},
+	}, generation.RemovedBlocks)
+}
+
+func TestGenerateIncremental(t *testing.T) {
+	createContainer := func(id string, domain string, ip string) types.Container {
+		return types.Container{
+			ID: id,
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: ip,
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               domain,
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			},
+		}
+	}
+
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createContainer("A", "a.example.com", "172.17.0.2"),
+		createContainer("B", "b.example.com", "172.17.0.3"),
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	})
+
+	generation := generator.Generate(zap.NewNop())
+	assert.Equal(t, "a.example.com {\n"+
+		"	reverse_proxy 172.17.0.2\n"+
+		"}\n"+
+		"b.example.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Only invalidated resources are refreshed
+	dockerClient.ContainersData = []types.Container{
+		createContainer("A", "a.example.com", "172.17.0.4"),
+		createContainer("B", "b.example.com", "172.17.0.5"),
+		createContainer("C", "c.example.com", "172.17.0.6"),
+	}
+	generator.Invalidate("container", "A")
+	generator.Invalidate("container", "C")
+
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "a.example.com {\n"+
+		"	reverse_proxy 172.17.0.4\n"+
+		"}\n"+
+		"b.example.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n"+
+		"c.example.com {\n"+
+		"	reverse_proxy 172.17.0.6\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Removed resources are dropped from cache
+	dockerClient.ContainersData = dockerClient.ContainersData[1:]
+	generator.Invalidate("container", "A")
+
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "b.example.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n"+
+		"c.example.com {\n"+
+		"	reverse_proxy 172.17.0.6\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Full generation reconciles everything
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, "b.example.com {\n"+
+		"	reverse_proxy 172.17.0.5\n"+
+		"}\n"+
+		"c.example.com {\n"+
+		"	reverse_proxy 172.17.0.6\n"+
+		"}\n", string(generation.Caddyfile))
+}
+
+func TestGenerateIncremental_SameAsFull(t *testing.T) {
+	createContainer := func(id string, ip string) types.Container {
+		return types.Container{
+			ID: id,
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress: ip,
+						NetworkID: caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "example.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+			},
+		}
+	}
+
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createContainer("B", "172.17.0.3"),
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	})
+	generator.Generate(zap.NewNop())
+
+	// Docker lists newer containers first
+	dockerClient.ContainersData = []types.Container{
+		createContainer("A", "172.17.0.2"),
+		createContainer("B", "172.17.0.3"),
+	}
+	generator.Invalidate("container", "A")
+
+	const expectedCaddyfile = "example.com {\n" +
+		"	reverse_proxy 172.17.0.2 172.17.0.3\n" +
+		"}\n"
+
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, expectedCaddyfile, string(generation.Caddyfile))
+
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, expectedCaddyfile, string(generation.Caddyfile))
+}
+
 func testGeneration(
 	t *testing.T,
 	dockerClient docker.Client,
@@ -189,6 +458,29 @@ func createBasicDockerClientMock() *docker.ClientMock {
 	}
 }
 
+// createNetworkContainer creates a container in caddy network, with a copy of labels
+func createNetworkContainer(id string, name string, ip string, labels map[string]string) types.Container {
+	container := types.Container{
+		ID: id,
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"caddy-network": {
+					IPAddress: ip,
+					NetworkID: caddyNetworkID,
+				},
+			},
+		},
+		Labels: map[string]string{},
+	}
+	if name != "" {
+		container.Names = []string{"/" + name}
+	}
+	for label, value := range labels {
+		container.Labels[label] = value
+	}
+	return container
+}
+
 func createDockerUtilsMock() *docker.UtilsMock {
 	return &docker.UtilsMock{
 		MockGetCurrentContainerID: func() (string, error) {
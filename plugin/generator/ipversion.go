@@ -0,0 +1,39 @@
+package generator
+
+import (
+	"net"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+)
+
+// selectIPAddresses selects the addresses of a single network endpoint according to the ip-version option.
+// Empty and invalid addresses are ignored.
+func (g *CaddyfileGenerator) selectIPAddresses(addresses []string) []string {
+	ipv4, ipv6 := []string{}, []string{}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, address)
+		} else {
+			ipv6 = append(ipv6, address)
+		}
+	}
+
+	switch g.options.IPVersion {
+	case config.PreferIPv6:
+		if len(ipv6) > 0 {
+			return ipv6
+		}
+		return ipv4
+	case config.BothIPVersions:
+		return append(ipv4, ipv6...)
+	default:
+		if len(ipv4) > 0 {
+			return ipv4
+		}
+		return ipv6
+	}
+}
@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func createDualStackDockerClient() *docker.ClientMock {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		{
+			ID: "CONTAINER-ID",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"caddy-network": {
+						IPAddress:         "172.17.0.2",
+						GlobalIPv6Address: "fd00::2",
+						NetworkID:         caddyNetworkID,
+					},
+				},
+			},
+			Labels: map[string]string{
+				fmtLabel("%s"):               "container.example.com",
+				fmtLabel("%s.reverse_proxy"): "{{upstreams http 8080}}",
+			},
+		},
+	}
+	dockerClient.ServicesData = []swarm.Service{
+		createReferencedService("SERVICE-ID", "service", map[string]string{
+			fmtLabel("%s"):               "service.example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams}}",
+		}),
+	}
+	dockerClient.TasksData = []swarm.Task{
+		{
+			ServiceID:    "SERVICE-ID",
+			DesiredState: swarm.TaskStateRunning,
+			Status:       swarm.TaskStatus{State: swarm.TaskStateRunning},
+			NetworksAttachments: []swarm.NetworkAttachment{
+				{
+					Network:   swarm.Network{ID: caddyNetworkID},
+					Addresses: []string{"10.0.0.2/24", "fd00:1::2/64"},
+				},
+			},
+		},
+	}
+	return dockerClient
+}
+
+func TestIPVersion_IPv4(t *testing.T) {
+	const expectedCaddyfile = "container.example.com {\n" +
+		"	reverse_proxy http://172.17.0.2:8080\n" +
+		"}\n" +
+		"service.example.com {\n" +
+		"	reverse_proxy 10.0.0.2\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, createDualStackDockerClient(), func(options *config.Options) {
+		options.ProxyServiceTasks = true
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestIPVersion_IPv6(t *testing.T) {
+	const expectedCaddyfile = "container.example.com {\n" +
+		"	reverse_proxy http://[fd00::2]:8080\n" +
+		"}\n" +
+		"service.example.com {\n" +
+		"	reverse_proxy fd00:1::2\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, createDualStackDockerClient(), func(options *config.Options) {
+		options.ProxyServiceTasks = true
+		options.IPVersion = config.PreferIPv6
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestIPVersion_Both(t *testing.T) {
+	const expectedCaddyfile = "container.example.com {\n" +
+		"	reverse_proxy http://172.17.0.2:8080 http://[fd00::2]:8080\n" +
+		"}\n" +
+		"service.example.com {\n" +
+		"	reverse_proxy 10.0.0.2 fd00:1::2\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, createDualStackDockerClient(), func(options *config.Options) {
+		options.ProxyServiceTasks = true
+		options.IPVersion = config.BothIPVersions
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestIPVersion_Fallback(t *testing.T) {
+	dockerClient := createDualStackDockerClient()
+	dockerClient.ContainersData[0].NetworkSettings.Networks["caddy-network"].IPAddress = ""
+
+	const expectedCaddyfile = "container.example.com {\n" +
+		"	reverse_proxy http://[fd00::2]:8080\n" +
+		"}\n" +
+		"service.example.com {\n" +
+		"	reverse_proxy 10.0.0.2\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, func(options *config.Options) {
+		options.ProxyServiceTasks = true
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestIPVersion_FormatUpstreams(t *testing.T) {
+	tests := []struct {
+		options  []interface{}
+		expected string
+	}{
+		// Caddy brackets bare addresses when adding the default port
+		{options: []interface{}{}, expected: "fd00::2"},
+		{options: []interface{}{8080}, expected: "[fd00::2]:8080"},
+		{options: []interface{}{"https"}, expected: "https://[fd00::2]"},
+		{options: []interface{}{"https", 8443}, expected: "https://[fd00::2]:8443"},
+	}
+	for _, test := range tests {
+		upstreams, err := formatUpstreams([]string{"fd00::2"}, test.options, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, upstreams)
+	}
+}
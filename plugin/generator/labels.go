@@ -1,6 +1,8 @@
 package generator
 
 import (
+	"errors"
+	"net"
 	"strconv"
 	"strings"
 	"text/template"
@@ -10,22 +12,16 @@ import (
 
 type targetsProvider func() ([]string, error)
 
-func labelsToCaddyfile(labels map[string]string, templateData interface{}, getTargets targetsProvider) (*caddyfile.Container, error) {
+// labelsToCaddyfile converts labels into caddyfile, extending template functions with funcs.
+// getPort discovers the port of upstreams requested with auto, and may be nil when it is not supported.
+func labelsToCaddyfile(labels map[string]string, templateData interface{}, getTargets targetsProvider, getPort portProvider, funcs template.FuncMap) (*caddyfile.Container, error) {
 	funcMap := template.FuncMap{
 		"upstreams": func(options ...interface{}) (string, error) {
 			targets, err := getTargets()
-			transformed := []string{}
-			for _, target := range targets {
-				for _, param := range options {
-					if protocol, isProtocol := param.(string); isProtocol {
-						target = protocol + "://" + target
-					} else if port, isPort := param.(int); isPort {
-						target = target + ":" + strconv.Itoa(port)
-					}
-				}
-				transformed = append(transformed, target)
+			if err != nil {
+				return "", err
 			}
-			return strings.Join(transformed, " "), err
+			return formatUpstreams(targets, options, getPort)
 		},
 		"http": func() string {
 			return "http"
@@ -33,7 +29,61 @@ func labelsToCaddyfile(labels map[string]string, templateData interface{}, getTa
 		"https": func() string {
 			return "https"
 		},
+		"auto": func() autoPort {
+			return autoPort{}
+		},
+	}
+	for name, function := range funcs {
+		funcMap[name] = function
 	}
 
 	return caddyfile.FromLabels(labels, templateData, funcMap)
 }
+
+// formatUpstreams applies upstreams template options, protocol and port, to targets.
+// The auto port option is resolved with getPort.
+func formatUpstreams(targets []string, options []interface{}, getPort portProvider) (string, error) {
+	resolvedOptions := make([]interface{}, len(options))
+	for i, param := range options {
+		if _, isAuto := param.(autoPort); isAuto {
+			if getPort == nil {
+				return "", errors.New("auto port is not supported by these upstreams")
+			}
+			port, err := getPort()
+			if err != nil {
+				return "", err
+			}
+			param = port
+		}
+		resolvedOptions[i] = param
+	}
+
+	// IPv6 addresses are bracketed only when followed by a port or preceded by a protocol,
+	// caddy brackets bare addresses itself when adding the default port
+	bracketIPv6 := false
+	for _, param := range resolvedOptions {
+		switch param.(type) {
+		case string, int:
+			bracketIPv6 = true
+		}
+	}
+
+	transformed := []string{}
+	for _, target := range targets {
+		if ip := net.ParseIP(target); bracketIPv6 && ip != nil && ip.To4() == nil {
+			target = "[" + target + "]"
+		}
+		// Unix sockets and addresses with a port, like the ones from dial labels, are kept as they are
+		isUnixSocket := strings.HasPrefix(target, "unix/")
+		_, _, hasPortErr := net.SplitHostPort(target)
+		for _, param := range resolvedOptions {
+			if protocol, isProtocol := param.(string); isProtocol && !isUnixSocket {
+				target = protocol + "://" + target
+			} else if port, isPort := param.(int); isPort && !isUnixSocket && hasPortErr != nil {
+				target = target + ":" + strconv.Itoa(port)
+			}
+		}
+		transformed = append(transformed, target)
+	}
+	return strings.Join(transformed, " "), nil
+}
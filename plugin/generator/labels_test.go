@@ -48,7 +48,7 @@ func TestLabelsToCaddyfile(t *testing.T) {
 		// convert the labels to a Caddyfile
 		caddyfileBlock, err := labelsToCaddyfile(labels, nil, func() ([]string, error) {
 			return []string{"target"}, nil
-		})
+		}, nil, nil)
 
 		// if the result is nil then we expect an empty Caddyfile
 		// or an error message prefixed with "err: "
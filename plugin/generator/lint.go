@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+)
+
+// LintStubUpstream is the address returned by upstreams when linting labels
+const LintStubUpstream = "10.0.0.1"
+
+// lintStubPort is the port discovered by auto when linting labels
+const lintStubPort = 80
+
+func getLintStubPort() (int, error) {
+	return lintStubPort, nil
+}
+
+// lintFuncs resolve template functions that reference other resources to LintStubUpstream
+var lintFuncs = template.FuncMap{
+	"composeUpstreams": func(options ...interface{}) (string, error) {
+		return formatUpstreams([]string{LintStubUpstream}, options, getLintStubPort)
+	},
+	"upstreamsOf": func(name string, options ...interface{}) (string, error) {
+		return formatUpstreams([]string{LintStubUpstream}, options, getLintStubPort)
+	},
+	"upstreamsWhere": func(selector string, options ...interface{}) (string, error) {
+		if _, err := parseLabelSelector(selector); err != nil {
+			return "", err
+		}
+		return formatUpstreams([]string{LintStubUpstream}, options, getLintStubPort)
+	},
+}
+
+// LintError is a problem found in the labels of a resource
+type LintError struct {
+	// Label that caused the error, or empty when it couldn't be determined
+	Label string
+	Err   error
+}
+
+func (e *LintError) Error() string {
+	if e.Label == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("label %s: %s", e.Label, e.Err.Error())
+}
+
+// LintLabels validates labels without a docker host, converting them into a caddyfile
+// and adapting it into json config. Upstreams resolve to LintStubUpstream.
+func LintLabels(labelPrefix string, labels map[string]string, templateData interface{}) *LintError {
+	labelRegex := createLabelRegex(labelPrefix)
+	caddyLabels := map[string]string{}
+	for label, value := range labels {
+		if labelRegex.MatchString(label) {
+			caddyLabels[label] = value
+		}
+	}
+	if len(caddyLabels) == 0 {
+		return nil
+	}
+
+	err := adaptLabels(caddyLabels, templateData)
+	if err == nil {
+		return nil
+	}
+
+	var labelError *caddyfile.LabelError
+	if errors.As(err, &labelError) {
+		return &LintError{Label: labelError.Label, Err: labelError.Err}
+	}
+
+	// Find the deepest label that fixes the error when removed
+	for _, label := range sortLabelsByDepth(caddyLabels) {
+		if adaptLabels(withoutLabel(caddyLabels, label), templateData) == nil {
+			return &LintError{Label: label, Err: err}
+		}
+	}
+
+	return &LintError{Err: err}
+}
+
+func adaptLabels(labels map[string]string, templateData interface{}) error {
+	container, err := labelsToCaddyfile(labels, templateData, func() ([]string, error) {
+		return []string{LintStubUpstream}, nil
+	}, getLintStubPort, lintFuncs)
+	if err != nil {
+		return err
+	}
+	if _, removedBlocks := extractCanary(container); len(removedBlocks) > 0 {
+		return errors.New(removedBlocks[0].Error)
+	}
+	_, _, err = caddyconfig.GetAdapter("caddyfile").Adapt(container.Marshal(), nil)
+	return err
+}
+
+// withoutLabel removes a label and all labels nested under it
+func withoutLabel(labels map[string]string, labelToRemove string) map[string]string {
+	filtered := map[string]string{}
+	for label, value := range labels {
+		if label != labelToRemove && !strings.HasPrefix(label, labelToRemove+".") {
+			filtered[label] = value
+		}
+	}
+	return filtered
+}
+
+func sortLabelsByDepth(labels map[string]string) []string {
+	sorted := make([]string, 0, len(labels))
+	for label := range labels {
+		sorted = append(sorted, label)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		depthI, depthJ := strings.Count(sorted[i], "."), strings.Count(sorted[j], ".")
+		if depthI != depthJ {
+			return depthI > depthJ
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintLabels_Valid(t *testing.T) {
+	lintError := LintLabels(DefaultLabelPrefix, map[string]string{
+		fmtLabel("%s"):               "example.com",
+		fmtLabel("%s.reverse_proxy"): "{{upstreams 8080}}",
+		"other":                      "{{invalid}}",
+	}, nil)
+
+	assert.Nil(t, lintError)
+}
+
+func TestLintLabels_TemplateError(t *testing.T) {
+	lintError := LintLabels(DefaultLabelPrefix, map[string]string{
+		fmtLabel("%s"):               "example.com",
+		fmtLabel("%s.reverse_proxy"): "{{upstreams 8080}}",
+		fmtLabel("%s.respond"):       "{{index .Names 1}}",
+	}, &types.Container{Names: []string{"/container"}})
+
+	assert.NotNil(t, lintError)
+	assert.Equal(t, fmtLabel("%s.respond"), lintError.Label)
+}
+
+func TestLintLabels_AdaptError(t *testing.T) {
+	lintError := LintLabels(DefaultLabelPrefix, map[string]string{
+		fmtLabel("%s"):                              "example.com",
+		fmtLabel("%s.reverse_proxy"):                "{{upstreams 8080}}",
+		fmtLabel("%s.reverse_proxy.lb_policy"):      "round_robin",
+		fmtLabel("%s.reverse_proxy.invalid_option"): "value",
+		fmtLabel("%s.encode"):                       "gzip",
+	}, nil)
+
+	assert.NotNil(t, lintError)
+	assert.Equal(t, fmtLabel("%s.reverse_proxy.invalid_option"), lintError.Label)
+	assert.Contains(t, lintError.Error(), "invalid_option")
+}
@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// autoPort is the value of the auto template function, replaced by the port discovered for upstreams
+type autoPort struct{}
+
+// portProvider discovers the port of upstreams
+type portProvider func() (int, error)
+
+// getContainerPort discovers the single TCP port exposed or published by a container
+func getContainerPort(container *types.Container) (int, error) {
+	ports := map[int]bool{}
+	for _, port := range container.Ports {
+		if port.Type == "" || port.Type == "tcp" {
+			ports[int(port.PrivatePort)] = true
+		}
+	}
+	return getSinglePort(ports, "container "+getContainerName(container))
+}
+
+// getServicePort discovers the single TCP target port published by a swarm service
+func getServicePort(service *swarm.Service) (int, error) {
+	ports := map[int]bool{}
+	for _, port := range service.Endpoint.Ports {
+		if port.Protocol == "" || port.Protocol == swarm.PortConfigProtocolTCP {
+			ports[int(port.TargetPort)] = true
+		}
+	}
+	return getSinglePort(ports, "service "+service.Spec.Name)
+}
+
+func getSinglePort(ports map[int]bool, description string) (int, error) {
+	if len(ports) == 0 {
+		return 0, fmt.Errorf("%s exposes no ports, set the upstreams port explicitly", description)
+	}
+	if len(ports) > 1 {
+		sorted := []int{}
+		for port := range ports {
+			sorted = append(sorted, port)
+		}
+		sort.Ints(sorted)
+		return 0, fmt.Errorf("%s exposes multiple ports %v, set the upstreams port explicitly", description, sorted)
+	}
+	for port := range ports {
+		return port, nil
+	}
+	return 0, nil
+}
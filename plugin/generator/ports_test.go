@@ -0,0 +1,76 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPorts_Auto(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	container := createNetworkContainer("CONTAINER-ID", "container", "172.17.0.2", map[string]string{
+		fmtLabel("%s"):               "example.com",
+		fmtLabel("%s.reverse_proxy"): "{{upstreams https auto}}",
+	})
+	// Published ports are listed once per host address
+	container.Ports = []types.Port{
+		{IP: "0.0.0.0", PrivatePort: 8080, PublicPort: 80, Type: "tcp"},
+		{IP: "::", PrivatePort: 8080, PublicPort: 80, Type: "tcp"},
+		{PrivatePort: 5353, Type: "udp"},
+	}
+	dockerClient.ContainersData = []types.Container{container}
+	dockerClient.ServicesData = []swarm.Service{
+		createReferencedService("SERVICE-ID", "service", map[string]string{
+			fmtLabel("%s"):               "service.example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreams auto}}",
+		}),
+	}
+	dockerClient.ServicesData[0].Endpoint.Ports = []swarm.PortConfig{
+		{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 3000, PublishedPort: 80},
+	}
+
+	const expectedCaddyfile = "example.com {\n" +
+		"	reverse_proxy https://172.17.0.2:8080\n" +
+		"}\n" +
+		"service.example.com {\n" +
+		"	reverse_proxy service:3000\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+}
+
+func TestPorts_AutoAmbiguous(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	container := createNetworkContainer("CONTAINER-ID", "container", "172.17.0.2", map[string]string{
+		fmtLabel("%s"):               "example.com",
+		fmtLabel("%s.reverse_proxy"): "{{upstreams auto}}",
+	})
+	container.Ports = []types.Port{
+		{PrivatePort: 8080, Type: "tcp"},
+		{PrivatePort: 443, Type: "tcp"},
+	}
+	dockerClient.ContainersData = []types.Container{container}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix: DefaultLabelPrefix,
+	})
+	generation := generator.Generate(zap.NewNop())
+
+	assert.Equal(t, "# Empty caddyfile", string(generation.Caddyfile))
+	assert.Equal(t, []RemovedBlock{
+		{
+			Error: "label caddy.reverse_proxy: template: :1:2: executing \"\" at <upstreams auto>: error calling upstreams: " +
+				"container container exposes multiple ports [443 8080], set the upstreams port explicitly",
+			Provenance: []*caddyfile.Provenance{
+				{Kind: "container", ID: "CONTAINER-ID", Name: "container", Label: "caddy.reverse_proxy"},
+			},
+		},
+	}, generation.RemovedBlocks)
+}
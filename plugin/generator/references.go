@@ -0,0 +1,162 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"go.uber.org/zap"
+)
+
+// resourceMatcher selects containers and services referenced by template functions, by name and labels
+type resourceMatcher func(name string, labels map[string]string) bool
+
+// referenceFuncs creates template functions that resolve upstreams of other resources.
+// Resources using them are marked dynamic, to be refreshed when other resources change.
+func (g *CaddyfileGenerator) referenceFuncs(resource *cachedResource, logger *zap.Logger) template.FuncMap {
+	return template.FuncMap{
+		"upstreamsOf": func(name string, options ...interface{}) (string, error) {
+			resource.dynamic = true
+			upstreams, err := g.formatReferencedUpstreams(func(resourceName string, labels map[string]string) bool {
+				return resourceName == name || labels[composeServiceLabel] == name
+			}, false, options, logger)
+			if err == nil && upstreams == "" {
+				logger.Debug("No upstreams found for reference", zap.String("name", name))
+			}
+			return upstreams, err
+		},
+		"upstreamsWhere": func(selector string, options ...interface{}) (string, error) {
+			resource.dynamic = true
+			match, err := parseLabelSelector(selector)
+			if err != nil {
+				return "", err
+			}
+			upstreams, err := g.formatReferencedUpstreams(match, true, options, logger)
+			if err == nil && upstreams == "" {
+				logger.Debug("No upstreams found for reference", zap.String("selector", selector))
+			}
+			return upstreams, err
+		},
+	}
+}
+
+// parseLabelSelector parses comma separated label requirements, all of them must match:
+// key=value, key!=value, or key for labels that are present
+func parseLabelSelector(selector string) (resourceMatcher, error) {
+	type requirement struct {
+		key      string
+		value    string
+		operator string
+	}
+
+	requirements := []requirement{}
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		var req requirement
+		if index := strings.Index(part, "!="); index >= 0 {
+			req = requirement{key: part[:index], value: part[index+2:], operator: "!="}
+		} else if index := strings.Index(part, "="); index >= 0 {
+			req = requirement{key: part[:index], value: part[index+1:], operator: "="}
+		} else {
+			req = requirement{key: part}
+		}
+		req.key, req.value = strings.TrimSpace(req.key), strings.TrimSpace(req.value)
+		if req.key == "" {
+			return nil, fmt.Errorf("invalid label selector %q", selector)
+		}
+		requirements = append(requirements, req)
+	}
+
+	return func(name string, labels map[string]string) bool {
+		for _, req := range requirements {
+			value, exists := labels[req.key]
+			switch req.operator {
+			case "=":
+				if !exists || value != req.value {
+					return false
+				}
+			case "!=":
+				if exists && value == req.value {
+					return false
+				}
+			default:
+				if !exists {
+					return false
+				}
+			}
+		}
+		return true
+	}, nil
+}
+
+// formatReferencedUpstreams formats upstreams of all matching containers, followed by upstreams of all matching services.
+// Each resource discovers its own auto port. When tasks is true, services always resolve to the IPs of their running tasks.
+func (g *CaddyfileGenerator) formatReferencedUpstreams(match resourceMatcher, tasks bool, options []interface{}, logger *zap.Logger) (string, error) {
+	// Problems of referenced resources are logged when generating their own caddyfiles
+	referenceLogger := zap.NewNop()
+
+	// Draining containers are kept, to be separated from the other ones
+	containers := []*types.Container{}
+	for _, container := range g.withUnlistedDraining(g.containers.containers) {
+		if match(getContainerName(container), container.Labels) {
+			containers = append(containers, container)
+		}
+	}
+	sortContainers(containers)
+
+	services := []*swarm.Service{}
+	for id := range g.services.services {
+		service := g.services.services[id]
+		if match(service.Spec.Name, service.Spec.Labels) {
+			services = append(services, &service)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Spec.Name < services[j].Spec.Name
+	})
+
+	upstreams := []string{}
+	appendUpstreams := func(targets []string, getPort portProvider) error {
+		if len(targets) == 0 {
+			return nil
+		}
+		formatted, err := formatUpstreams(targets, options, getPort)
+		if err != nil {
+			return err
+		}
+		upstreams = append(upstreams, formatted)
+		return nil
+	}
+
+	for _, container := range containers {
+		ips, err := g.getContainerUpstreams(container, referenceLogger)
+		if err != nil {
+			return "", err
+		}
+		container := container
+		if err := appendUpstreams(ips, func() (int, error) { return getContainerPort(container) }); err != nil {
+			return "", err
+		}
+	}
+	for _, service := range services {
+		var targets []string
+		var err error
+		if tasks {
+			targets, err = g.getServiceTasksIps(service, referenceLogger, true)
+		} else {
+			targets, err = g.getServiceProxyTargets(service, referenceLogger, true)
+		}
+		if err != nil {
+			logger.Error("Failed to get upstreams of referenced service", zap.String("service", service.Spec.Name), zap.Error(err))
+			return "", err
+		}
+		service := service
+		if err := appendUpstreams(targets, func() (int, error) { return getServicePort(service) }); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(upstreams, " "), nil
+}
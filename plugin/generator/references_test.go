@@ -0,0 +1,260 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/docker"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func createReferencedService(id string, name string, labels map[string]string) swarm.Service {
+	return swarm.Service{
+		ID: id,
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{
+				Name:   name,
+				Labels: labels,
+			},
+		},
+		Endpoint: swarm.Endpoint{
+			VirtualIPs: []swarm.EndpointVirtualIP{
+				{
+					NetworkID: caddyNetworkID,
+				},
+			},
+		},
+	}
+}
+
+func TestReferences_UpstreamsOf(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createNetworkContainer("GATEWAY", "gateway", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):                           "example.com",
+			fmtLabel("%s.reverse_proxy_0"):           "/api/* {{upstreamsOf \"api\" 8080}}",
+			fmtLabel("%s.reverse_proxy_1"):           "/static/* {{upstreamsOf \"static\" 80}}",
+			fmtLabel("%s.reverse_proxy_2"):           "/web/* {{upstreamsOf \"web\"}}",
+			fmtLabel("%s.reverse_proxy_2.lb_policy"): "round_robin",
+			fmtLabel("%s.reverse_proxy_3"):           "/missing/* {{upstreamsOf \"missing\"}}",
+			fmtLabel("%s.reverse_proxy_3.to"):        "127.0.0.1",
+		}),
+		createNetworkContainer("API", "api", "172.17.0.3", map[string]string{}),
+		createNetworkContainer("WEB-2", "project_web_2", "172.17.0.5", map[string]string{
+			composeServiceLabel:         "web",
+			composeContainerNumberLabel: "2",
+		}),
+		createNetworkContainer("WEB-1", "project_web_1", "172.17.0.4", map[string]string{
+			composeServiceLabel:         "web",
+			composeContainerNumberLabel: "1",
+		}),
+	}
+	dockerClient.ServicesData = []swarm.Service{
+		createReferencedService("STATIC", "static", map[string]string{}),
+	}
+
+	const expectedCaddyfile = "example.com {\n" +
+		"	reverse_proxy /api/* 172.17.0.3:8080\n" +
+		"	reverse_proxy /missing/* {\n" +
+		"		to 127.0.0.1\n" +
+		"	}\n" +
+		"	reverse_proxy /static/* static:80\n" +
+		"	reverse_proxy /web/* 172.17.0.4 172.17.0.5 {\n" +
+		"		lb_policy round_robin\n" +
+		"	}\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+}
+
+func TestReferences_Incremental(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createNetworkContainer("GATEWAY", "gateway", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreamsOf \"api\"}}",
+		}),
+	}
+	dockerClient.ServicesData = []swarm.Service{
+		createReferencedService("API", "api", map[string]string{}),
+	}
+	dockerClient.TasksData = []swarm.Task{
+		{
+			ServiceID:    "API",
+			DesiredState: swarm.TaskStateRunning,
+			Status:       swarm.TaskStatus{State: swarm.TaskStateRunning},
+			NetworksAttachments: []swarm.NetworkAttachment{
+				{
+					Network:   swarm.Network{ID: caddyNetworkID},
+					Addresses: []string{"10.0.0.2/24"},
+				},
+			},
+		},
+	}
+
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix:       DefaultLabelPrefix,
+		ProxyServiceTasks: true,
+	})
+
+	generation := generator.Generate(zap.NewNop())
+	assert.Equal(t, "example.com {\n"+
+		"	reverse_proxy 10.0.0.2\n"+
+		"}\n", string(generation.Caddyfile))
+
+	// Changes of referenced services refresh the referencing container
+	dockerClient.TasksData[0].NetworksAttachments[0].Addresses = []string{"10.0.0.3/24"}
+	generator.Invalidate("service", "API")
+
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, "example.com {\n"+
+		"	reverse_proxy 10.0.0.3\n"+
+		"}\n", string(generation.Caddyfile))
+}
+
+func TestReferences_DrainingUpstreamsOf(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createNetworkContainer("GATEWAY", "gateway", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreamsOf \"api\"}}",
+		}),
+		createNetworkContainer("API-1", "project_api_1", "172.17.0.3", map[string]string{
+			composeServiceLabel: "api",
+		}),
+		createNetworkContainer("API-2", "project_api_2", "172.17.0.4", map[string]string{
+			composeServiceLabel: "api",
+		}),
+	}
+	testReferencedDrain(t, dockerClient, "API-2", "example.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 172.17.0.4\n"+
+		"}\n", "example.com {\n"+
+		"	reverse_proxy 172.17.0.3\n"+
+		"}\n")
+}
+
+// testReferencedDrain stops a referenced container, expecting it to drain before it is removed
+func testReferencedDrain(t *testing.T, dockerClient *docker.ClientMock, id string, drainingCaddyfile string, drainedCaddyfile string) {
+	generator := CreateGenerator(dockerClient, createDockerUtilsMock(), &config.Options{
+		LabelPrefix:  DefaultLabelPrefix,
+		DrainTimeout: time.Minute,
+	})
+	generator.Generate(zap.NewNop())
+
+	generator.Drain(id)
+	generator.Invalidate("container", id)
+	generator.GenerateIncremental(zap.NewNop())
+	listed := []types.Container{}
+	for _, container := range dockerClient.ContainersData {
+		if container.ID != id {
+			listed = append(listed, container)
+		}
+	}
+	dockerClient.ContainersData = listed
+	generator.Invalidate("container", id)
+
+	generation := generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, drainingCaddyfile, string(generation.Caddyfile))
+	generation = generator.Generate(zap.NewNop())
+	assert.Equal(t, drainingCaddyfile, string(generation.Caddyfile))
+
+	generator.EndDrain(id)
+	generation = generator.GenerateIncremental(zap.NewNop())
+	assert.Equal(t, drainedCaddyfile, string(generation.Caddyfile))
+}
+
+func TestReferences_UpstreamsWhere(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createNetworkContainer("GATEWAY", "gateway", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreamsWhere \"app=payments, tier=web, canary!=true\" 80}}",
+		}),
+		createNetworkContainer("BLUE", "blue", "172.17.0.3", map[string]string{
+			"app":  "payments",
+			"tier": "web",
+		}),
+		createNetworkContainer("GREEN", "green", "172.17.0.4", map[string]string{
+			"app":    "payments",
+			"tier":   "web",
+			"canary": "true",
+		}),
+		createNetworkContainer("WORKER", "worker", "172.17.0.5", map[string]string{
+			"app":  "payments",
+			"tier": "worker",
+		}),
+	}
+	dockerClient.ServicesData = []swarm.Service{
+		createReferencedService("PAYMENTS", "payments", map[string]string{
+			"app":  "payments",
+			"tier": "web",
+		}),
+	}
+	dockerClient.TasksData = []swarm.Task{
+		{
+			ServiceID:    "PAYMENTS",
+			DesiredState: swarm.TaskStateRunning,
+			Status:       swarm.TaskStatus{State: swarm.TaskStateRunning},
+			NetworksAttachments: []swarm.NetworkAttachment{
+				{
+					Network:   swarm.Network{ID: caddyNetworkID},
+					Addresses: []string{"10.0.0.2/24"},
+				},
+				{
+					Network:   swarm.Network{ID: "other-network-id"},
+					Addresses: []string{"10.1.0.2/24"},
+				},
+			},
+		},
+	}
+
+	const expectedCaddyfile = "example.com {\n" +
+		"	reverse_proxy 172.17.0.3:80 10.0.0.2:80\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, nil, expectedCaddyfile, expectedLogs)
+}
+
+func TestReferences_DrainingUpstreamsWhere(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+	dockerClient.ContainersData = []types.Container{
+		createNetworkContainer("GATEWAY", "gateway", "172.17.0.2", map[string]string{
+			fmtLabel("%s"):               "example.com",
+			fmtLabel("%s.reverse_proxy"): "{{upstreamsWhere \"app=payments\" 80}}",
+		}),
+		createNetworkContainer("BLUE", "blue", "172.17.0.3", map[string]string{
+			"app": "payments",
+		}),
+		createNetworkContainer("GREEN", "green", "172.17.0.4", map[string]string{
+			"app": "payments",
+		}),
+	}
+	testReferencedDrain(t, dockerClient, "BLUE", "example.com {\n"+
+		"	@caddy_docker_proxy_draining not path *\n"+
+		"	reverse_proxy 172.17.0.4:80\n"+
+		"	reverse_proxy @caddy_docker_proxy_draining 172.17.0.3:80\n"+
+		"}\n", "example.com {\n"+
+		"	reverse_proxy 172.17.0.4:80\n"+
+		"}\n")
+}
+
+func TestReferences_InvalidSelector(t *testing.T) {
+	_, err := parseLabelSelector("app=payments,,tier=web")
+	assert.EqualError(t, err, "invalid label selector \"app=payments,,tier=web\"")
+
+	lintErr := LintLabels(DefaultLabelPrefix, map[string]string{
+		"caddy":               "example.com",
+		"caddy.reverse_proxy": "{{upstreamsWhere \"=web\"}}",
+	}, nil)
+	assert.Equal(t, "caddy.reverse_proxy", lintErr.Label)
+}
@@ -12,12 +12,141 @@ import (
 	"go.uber.org/zap"
 )
 
-func (g *CaddyfileGenerator) getServiceCaddyfile(service *swarm.Service, logger *zap.Logger) (*caddyfile.Container, error) {
+// servicesSource provides caddyfiles from swarm services labels
+type servicesSource struct {
+	g     *CaddyfileGenerator
+	cache *resourceCache
+	// services of the last listing by ID, used to resolve upstreams referenced by other resources
+	services map[string]swarm.Service
+}
+
+func (source *servicesSource) Kind() string {
+	return "service"
+}
+
+func (source *servicesSource) Collect(logger *zap.Logger) ([]*Fragment, []string) {
+	g := source.g
+
+	if !g.swarmIsAvailable {
+		logger.Info("Skipping swarm services because swarm is not available")
+		source.services = map[string]swarm.Service{}
+		source.cache.invalidate()
+		return []*Fragment{}, []string{}
+	}
+
+	services, err := g.dockerClient.ServiceList(context.Background(), types.ServiceListOptions{})
+	if err != nil {
+		logger.Error("Failed to get Swarm services", zap.Error(err))
+		source.cache.invalidate()
+		return []*Fragment{}, []string{}
+	}
+
+	source.services = map[string]swarm.Service{}
+	for _, service := range services {
+		source.services[service.ID] = service
+	}
+
+	source.cache.reset()
+	for _, service := range services {
+		source.cache.add(source.collectService(&service, logger))
+	}
+
+	return source.cache.collect()
+}
+
+func (source *servicesSource) CollectChanged(logger *zap.Logger, ids []string) ([]*Fragment, []string) {
+	if !source.cache.initialized {
+		return source.Collect(logger)
+	}
+
+	g := source.g
+
+	// Update listing of all changed services before generating caddyfiles that may reference them
+	for _, id := range ids {
+		services, err := g.dockerClient.ServiceList(context.Background(), types.ServiceListOptions{
+			Filters: filters.NewArgs(filters.Arg("id", id)),
+		})
+		if err != nil {
+			logger.Error("Failed to get Swarm services", zap.String("serviceId", id), zap.Error(err))
+			return source.Collect(logger)
+		}
+
+		delete(source.services, id)
+		for _, service := range services {
+			source.services[service.ID] = service
+		}
+	}
+
+	for _, id := range ids {
+		if service, listed := source.services[id]; listed {
+			source.cache.set(source.collectService(&service, logger))
+		} else {
+			source.cache.delete(id)
+		}
+	}
+
+	return source.cache.collect()
+}
+
+func (source *servicesSource) CollectDynamic(logger *zap.Logger) ([]*Fragment, []string) {
+	for _, resource := range source.cache.resources {
+		if !resource.dynamic {
+			continue
+		}
+		if service, listed := source.services[resource.id]; listed {
+			source.cache.set(source.collectService(&service, logger))
+		}
+	}
+
+	return source.cache.collect()
+}
+
+func (source *servicesSource) collectService(service *swarm.Service, logger *zap.Logger) *cachedResource {
+	g := source.g
+	resource := &cachedResource{
+		id:                service.ID,
+		controlledServers: []string{},
+	}
+
+	logger.Debug("Swarm service", zap.String("service", service.Spec.Name))
+
+	if _, isControlledServer := service.Spec.Labels[g.options.ControlledServersLabel]; isControlledServer {
+		ips, err := g.getServiceTasksIps(service, logger, false)
+		if err != nil {
+			logger.Error("Failed to  get Swarm service IPs", zap.String("service", service.Spec.Name), zap.Error(err))
+		} else {
+			for _, ip := range ips {
+				if len(g.options.ControllerNetworks) == 0 || g.options.InControllerNetworks(net.ParseIP(ip)) {
+					resource.controlledServers = append(resource.controlledServers, ip)
+				}
+			}
+		}
+	}
+
+	// caddy. labels based config
+	serviceCaddyfile, err := g.getServiceCaddyfile(service, resource, logger)
+	if err != nil {
+		logger.Error("Failed to get Swarm service caddyfile", zap.String("service", service.Spec.Name), zap.Error(err))
+	}
+	resource.fragment = &Fragment{
+		Kind:      "service",
+		ID:        service.ID,
+		Name:      service.Spec.Name,
+		Caddyfile: serviceCaddyfile,
+		Err:       err,
+	}
+
+	return resource
+}
+
+func (g *CaddyfileGenerator) getServiceCaddyfile(service *swarm.Service, resource *cachedResource, logger *zap.Logger) (*caddyfile.Container, error) {
 	caddyLabels := g.filterLabels(service.Spec.Labels)
 
 	return labelsToCaddyfile(caddyLabels, service, func() ([]string, error) {
 		return g.getServiceProxyTargets(service, logger, true)
-	})
+	}, func() (int, error) {
+		return getServicePort(service)
+	}, g.referenceFuncs(resource, logger))
 }
 
 func (g *CaddyfileGenerator) getServiceProxyTargets(service *swarm.Service, logger *zap.Logger, ingress bool) ([]string, error) {
@@ -66,10 +195,12 @@ func (g *CaddyfileGenerator) getServiceTasksIps(service *swarm.Service, logger *
 			hasRunningTasks = true
 			for _, networkAttachment := range task.NetworksAttachments {
 				if !ingress || g.ingressNetworks[networkAttachment.Network.ID] {
+					attachmentIps := []string{}
 					for _, address := range networkAttachment.Addresses {
 						ipAddress, _, _ := net.ParseCIDR(address)
-						tasksIps = append(tasksIps, ipAddress.String())
+						attachmentIps = append(attachmentIps, ipAddress.String())
 					}
+					tasksIps = append(tasksIps, g.selectIPAddresses(attachmentIps)...)
 				}
 			}
 		}
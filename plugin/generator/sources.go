@@ -0,0 +1,37 @@
+package generator
+
+import (
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+
+	"go.uber.org/zap"
+)
+
+// Source provides caddyfile fragments to the generator, like docker containers or swarm services
+type Source interface {
+	// Collect returns the caddyfile fragments and controlled servers found in this source
+	Collect(logger *zap.Logger) ([]*Fragment, []string)
+}
+
+// IncrementalSource is a source that caches what each resource generated,
+// able to refresh only resources that changed since the last collect
+type IncrementalSource interface {
+	Source
+	// Kind of resources provided, matching docker events type
+	Kind() string
+	// CollectChanged refreshes resources with the given IDs and returns all cached fragments and controlled servers.
+	// It falls back to Collect when there is nothing cached yet.
+	CollectChanged(logger *zap.Logger, ids []string) ([]*Fragment, []string)
+	// CollectDynamic refreshes resources that reference other resources, once all sources were collected,
+	// and returns all cached fragments and controlled servers
+	CollectDynamic(logger *zap.Logger) ([]*Fragment, []string)
+}
+
+// Fragment is the caddyfile generated from a single resource of a source
+type Fragment struct {
+	Kind      string
+	ID        string
+	Name      string
+	Caddyfile *caddyfile.Container
+	// Err is set instead of Caddyfile when labels couldn't be converted into a caddyfile
+	Err error
+}
@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// StaticContainer is a pseudo container declared in a static file, to proxy backends outside docker
+type StaticContainer struct {
+	ID        string            `json:"id" yaml:"id"`
+	Name      string            `json:"name" yaml:"name"`
+	Labels    map[string]string `json:"labels" yaml:"labels"`
+	Upstreams []string          `json:"upstreams" yaml:"upstreams"`
+}
+
+// staticSource provides caddyfiles from pseudo containers declared in a JSON or YAML file
+type staticSource struct {
+	g *CaddyfileGenerator
+}
+
+func (source *staticSource) Collect(logger *zap.Logger) ([]*Fragment, []string) {
+	g := source.g
+	fragments := []*Fragment{}
+	path := g.options.StaticContainersPath
+
+	containers, err := readStaticContainers(path)
+	if err != nil {
+		logger.Error("Failed to read static containers", zap.String("path", path), zap.Error(err))
+		return fragments, nil
+	}
+
+	for _, container := range containers {
+		id := container.ID
+		if id == "" {
+			id = container.Name
+		}
+
+		containerCaddyfile, err := g.getStaticContainerCaddyfile(container)
+		if err != nil {
+			logger.Error("Failed to get static container caddyfile", zap.String("container", container.Name), zap.Error(err))
+		}
+		fragments = append(fragments, &Fragment{
+			Kind:      "static",
+			ID:        id,
+			Name:      container.Name,
+			Caddyfile: containerCaddyfile,
+			Err:       err,
+		})
+	}
+
+	return fragments, nil
+}
+
+func (g *CaddyfileGenerator) getStaticContainerCaddyfile(container *StaticContainer) (*caddyfile.Container, error) {
+	caddyLabels := g.filterLabels(container.Labels)
+
+	return labelsToCaddyfile(caddyLabels, container, func() ([]string, error) {
+		return container.Upstreams, nil
+	}, nil, nil)
+}
+
+func readStaticContainers(path string) ([]*StaticContainer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []*StaticContainer{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &containers)
+	} else {
+		err = yaml.Unmarshal(data, &containers)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+)
+
+func TestStatic_Yaml(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+
+	const expectedCaddyfile = "docs.example.com {\n" +
+		"	respond docs\n" +
+		"}\n" +
+		"legacy.example.com {\n" +
+		"	reverse_proxy 10.0.0.5:8080 10.0.0.6:8080\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, func(options *config.Options) {
+		options.StaticContainersPath = "./testdata/static/containers.yaml"
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestStatic_Json(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+
+	const expectedCaddyfile = "docs.example.com {\n" +
+		"	respond docs\n" +
+		"}\n" +
+		"legacy.example.com {\n" +
+		"	reverse_proxy 10.0.0.5:8080 10.0.0.6:8080\n" +
+		"}\n"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog
+
+	testGeneration(t, dockerClient, func(options *config.Options) {
+		options.StaticContainersPath = "./testdata/static/containers.json"
+	}, expectedCaddyfile, expectedLogs)
+}
+
+func TestStatic_MissingFile(t *testing.T) {
+	dockerClient := createBasicDockerClientMock()
+
+	const expectedCaddyfile = "# Empty caddyfile"
+
+	const expectedLogs = commonLogs + skipCaddyfileLog +
+		`ERROR	Failed to read static containers	{"path": "./testdata/static/missing.yaml", "error": "open ./testdata/static/missing.yaml: no such file or directory"}` + newLine
+
+	testGeneration(t, dockerClient, func(options *config.Options) {
+		options.StaticContainersPath = "./testdata/static/missing.yaml"
+	}, expectedCaddyfile, expectedLogs)
+}
@@ -11,7 +11,9 @@ require (
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/moby/term v0.0.0-20201216013528-df9cb8a40635 // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
+	github.com/prometheus/client_golang v1.9.0
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.16.0
+	gopkg.in/yaml.v2 v2.4.0
 
 )
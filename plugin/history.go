@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ConfigVersion is a generated json config kept in history
+type ConfigVersion struct {
+	Version   string          `json:"version"`
+	Generated time.Time       `json:"generated"`
+	Caddyfile string          `json:"caddyfile"`
+	Config    json.RawMessage `json:"config"`
+	// Bad versions were rejected by most servers, and are not pushed anymore
+	Bad bool `json:"bad"`
+	// Accepted versions were configured by at least one server
+	Accepted bool `json:"accepted"`
+	// Errors returned by servers that rejected this version
+	RejectedBy map[string]string `json:"rejectedBy,omitempty"`
+}
+
+// ConfigHistory keeps the last generated json configs, tracking which ones servers accepted or rejected
+type ConfigHistory struct {
+	mutex    sync.RWMutex
+	size     int
+	versions []*ConfigVersion
+	// Newest good version that left history, still served while newer versions are bad
+	evictedGood *ConfigVersion
+}
+
+// CreateConfigHistory creates a history keeping up to size versions
+func CreateConfigHistory(size int) *ConfigHistory {
+	// History always keeps the current version
+	if size < 1 {
+		size = 1
+	}
+	return &ConfigHistory{
+		size:     size,
+		versions: []*ConfigVersion{},
+	}
+}
+
+// Add adds a version as the newest one. Versions generated again keep the servers that rejected them.
+func (history *ConfigHistory) Add(version string, caddyfile []byte, configJSON []byte) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	configVersion := &ConfigVersion{
+		Version:    version,
+		Caddyfile:  string(caddyfile),
+		Config:     configJSON,
+		RejectedBy: map[string]string{},
+	}
+	if index := history.indexOf(version); index >= 0 {
+		configVersion = history.versions[index]
+		history.versions = append(history.versions[:index], history.versions[index+1:]...)
+	}
+	configVersion.Generated = time.Now()
+
+	history.versions = append(history.versions, configVersion)
+	if len(history.versions) > history.size {
+		for _, evicted := range history.versions[:len(history.versions)-history.size] {
+			if evicted.isGood() {
+				history.evictedGood = evicted
+			}
+		}
+		history.versions = history.versions[len(history.versions)-history.size:]
+	}
+}
+
+// Get returns a copy of a version, or nil when it is not in history
+func (history *ConfigHistory) Get(version string) *ConfigVersion {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	if index := history.indexOf(version); index >= 0 {
+		return history.versions[index].copy()
+	}
+	return nil
+}
+
+// IsBad checks if a version was rejected by most servers
+func (history *ConfigHistory) IsBad(version string) bool {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	index := history.indexOf(version)
+	return index >= 0 && history.versions[index].Bad
+}
+
+// Reject records that a server rejected a version, marking it bad when rejected by most of servers.
+// It returns if the version is bad.
+func (history *ConfigHistory) Reject(version string, server string, reason string, servers int) bool {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	index := history.indexOf(version)
+	if index < 0 {
+		return false
+	}
+	configVersion := history.versions[index]
+	configVersion.RejectedBy[server] = reason
+	if len(configVersion.RejectedBy)*2 > servers {
+		configVersion.Bad = true
+	}
+	return configVersion.Bad
+}
+
+// Accept records that a server configured a version
+func (history *ConfigHistory) Accept(version string) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	if index := history.indexOf(version); index >= 0 {
+		history.versions[index].Accepted = true
+	}
+}
+
+// LastGood returns a copy of the newest version accepted by servers that is not bad, or nil when there is none
+func (history *ConfigHistory) LastGood() *ConfigVersion {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	for i := len(history.versions) - 1; i >= 0; i-- {
+		if history.versions[i].isGood() {
+			return history.versions[i].copy()
+		}
+	}
+	if history.evictedGood != nil && history.evictedGood.isGood() {
+		return history.evictedGood.copy()
+	}
+	return nil
+}
+
+// Reset forgets servers that rejected a version, to push it again
+func (history *ConfigHistory) Reset(version string) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	if index := history.indexOf(version); index >= 0 {
+		history.versions[index].Bad = false
+		history.versions[index].RejectedBy = map[string]string{}
+	}
+}
+
+// Versions returns copies of all versions, from oldest to newest
+func (history *ConfigHistory) Versions() []*ConfigVersion {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	versions := make([]*ConfigVersion, len(history.versions))
+	for i, configVersion := range history.versions {
+		versions[i] = configVersion.copy()
+	}
+	return versions
+}
+
+func (history *ConfigHistory) indexOf(version string) int {
+	for i, configVersion := range history.versions {
+		if configVersion.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// isGood checks if a version was accepted by servers and can be pushed
+func (configVersion *ConfigVersion) isGood() bool {
+	return configVersion.Accepted && !configVersion.Bad
+}
+
+func (configVersion *ConfigVersion) copy() *ConfigVersion {
+	copied := *configVersion
+	copied.RejectedBy = make(map[string]string, len(configVersion.RejectedBy))
+	for server, reason := range configVersion.RejectedBy {
+		copied.RejectedBy[server] = reason
+	}
+	return &copied
+}
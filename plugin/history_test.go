@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHistory_Reject(t *testing.T) {
+	tests := []struct {
+		name      string
+		rejectors []string
+		servers   int
+		bad       bool
+	}{
+		{name: "no rejection", rejectors: []string{}, servers: 3, bad: false},
+		{name: "minority", rejectors: []string{"a"}, servers: 3, bad: false},
+		{name: "majority", rejectors: []string{"a", "b"}, servers: 3, bad: true},
+		{name: "half", rejectors: []string{"a", "b"}, servers: 4, bad: false},
+		{name: "same server twice", rejectors: []string{"a", "a"}, servers: 3, bad: false},
+		{name: "single server", rejectors: []string{"a"}, servers: 1, bad: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := CreateConfigHistory(10)
+			history.Add("v1", []byte("caddyfile"), []byte("{}"))
+			for _, server := range test.rejectors {
+				history.Reject("v1", server, "invalid", test.servers)
+			}
+			assert.Equal(t, test.bad, history.IsBad("v1"))
+			assert.Len(t, history.Get("v1").RejectedBy, len(uniqueStrings(test.rejectors)))
+		})
+	}
+}
+
+func TestConfigHistory_Size(t *testing.T) {
+	history := CreateConfigHistory(2)
+	history.Add("v1", []byte{}, []byte("{}"))
+	history.Add("v2", []byte{}, []byte("{}"))
+	history.Reject("v2", "a", "invalid", 1)
+	history.Add("v3", []byte{}, []byte("{}"))
+
+	// Versions generated again move to newest, keeping their rejections
+	history.Add("v2", []byte{}, []byte("{}"))
+
+	versions := history.Versions()
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "v3", versions[0].Version)
+	assert.Equal(t, "v2", versions[1].Version)
+	assert.True(t, versions[1].Bad)
+	assert.Nil(t, history.Get("v1"))
+	assert.False(t, history.IsBad("v1"))
+	assert.False(t, history.Reject("v1", "a", "invalid", 1))
+}
+
+func TestConfigHistory_Reset(t *testing.T) {
+	history := CreateConfigHistory(10)
+	history.Add("v1", []byte{}, []byte("{}"))
+	assert.True(t, history.Reject("v1", "a", "invalid", 1))
+
+	history.Reset("v1")
+	assert.False(t, history.IsBad("v1"))
+	assert.Empty(t, history.Get("v1").RejectedBy)
+}
+
+func TestConfigHistory_LastGood(t *testing.T) {
+	history := CreateConfigHistory(2)
+	assert.Nil(t, history.LastGood())
+
+	history.Add("v1", []byte{}, []byte(`{"v":1}`))
+	history.Accept("v1")
+	history.Add("v2", []byte{}, []byte(`{"v":2}`))
+	assert.Equal(t, "v1", history.LastGood().Version)
+
+	// Versions accepted by a few servers but rejected by most are not good
+	history.Accept("v2")
+	assert.Equal(t, "v2", history.LastGood().Version)
+	history.Reject("v2", "a", "invalid", 3)
+	history.Reject("v2", "b", "invalid", 3)
+	assert.Equal(t, "v1", history.LastGood().Version)
+
+	// Last good version is kept after it leaves history
+	history.Add("v3", []byte{}, []byte(`{"v":3}`))
+	history.Add("v4", []byte{}, []byte(`{"v":4}`))
+	assert.Nil(t, history.Get("v1"))
+	lastGood := history.LastGood()
+	assert.Equal(t, "v1", lastGood.Version)
+	assert.Equal(t, `{"v":1}`, string(lastGood.Config))
+}
+
+func uniqueStrings(values []string) []string {
+	unique := []string{}
+	for _, value := range values {
+		if !containsString(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// loadLastConfig loads the configuration a server was running before it restarted, to keep serving
+// it until controllers push a new one. Caddy autosaves every configuration it loads successfully
+// through its admin endpoint, which is how servers receive configurations from controllers.
+// It returns false when there is no configuration to load.
+func loadLastConfig(adminListen string) (bool, error) {
+	configJSON, err := readLastConfig(adminListen)
+	if configJSON == nil || err != nil {
+		return false, err
+	}
+
+	if err := caddy.Load(configJSON, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readLastConfig reads the last autosaved configuration, listening admin on adminListen.
+// It returns nil when there is no autosaved configuration.
+func readLastConfig(adminListen string) ([]byte, error) {
+	configJSON, err := ioutil.ReadFile(caddy.ConfigAutosavePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Server address may have changed since the configuration was saved
+	return addAdminListen(configJSON, adminListen)
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// useAutosavePath makes caddy autosave configurations to a temporary file
+func useAutosavePath(t *testing.T) string {
+	autosavePath := caddy.ConfigAutosavePath
+	caddy.ConfigAutosavePath = filepath.Join(t.TempDir(), "autosave.json")
+	t.Cleanup(func() {
+		caddy.ConfigAutosavePath = autosavePath
+	})
+	return caddy.ConfigAutosavePath
+}
+
+func TestLoadLastConfig_Missing(t *testing.T) {
+	useAutosavePath(t)
+
+	loaded, err := loadLastConfig("tcp/10.0.0.1:2019")
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+}
+
+func TestLoadLastConfig_Corrupt(t *testing.T) {
+	path := useAutosavePath(t)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"apps":`), 0600))
+
+	loaded, err := loadLastConfig("tcp/10.0.0.1:2019")
+	assert.Error(t, err)
+	assert.False(t, loaded)
+}
+
+func TestReadLastConfig_AdminListen(t *testing.T) {
+	path := useAutosavePath(t)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"admin":{"listen":"tcp/10.0.0.1:2019"},"apps":{"tls":{}}}`), 0600))
+
+	// Server got a new address since it saved the configuration
+	configJSON, err := readLastConfig("tcp/10.0.0.2:2019")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"admin":{"listen":"tcp/10.0.0.2:2019"},"apps":{"tls":{}}}`, string(configJSON))
+}
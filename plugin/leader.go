@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LeaseStore keeps the lease electing the leader among controller replicas
+type LeaseStore interface {
+	// Acquire takes the lease for holder when it is free or expired, or renews it when holder has it.
+	// It returns if holder has the lease.
+	Acquire(holder string, duration time.Duration) (bool, error)
+}
+
+// lease is the content of a lease file
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// Default and minimum durations of the leader lease. Leases are renewed three times per duration,
+// shorter leases expire before replicas can renew them.
+const (
+	defaultLeaderLeaseDuration = 15 * time.Second
+	minLeaderLeaseDuration     = 3 * time.Second
+)
+
+// Attempts and interval to take the lock of a lease file held by another replica
+const (
+	leaseLockAttempts = 20
+	leaseLockInterval = 50 * time.Millisecond
+)
+
+// FileLeaseStore keeps the lease in a file, shared by controllers through a volume.
+// Replicas read and write the lease holding a lock file created exclusively next to it,
+// so only one of them can take an expired lease. Writes replace the file atomically.
+type FileLeaseStore struct {
+	path string
+}
+
+// CreateFileLeaseStore creates a lease store in the file at path
+func CreateFileLeaseStore(path string) *FileLeaseStore {
+	return &FileLeaseStore{path: path}
+}
+
+// Acquire takes or renews the lease in the file
+func (store *FileLeaseStore) Acquire(holder string, duration time.Duration) (bool, error) {
+	unlock, err := store.lock(duration)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := store.read()
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.Holder != holder && time.Now().Before(current.Expires) {
+		return false, nil
+	}
+
+	if err := store.write(&lease{Holder: holder, Expires: time.Now().Add(duration)}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lock creates the lock file, waiting while another replica holds it.
+// Lock files contain a random token identifying who created them, so that replicas only remove the lock they took.
+func (store *FileLeaseStore) lock(duration time.Duration) (func(), error) {
+	lockPath := store.path + ".lock"
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = lockFile.WriteString(token)
+			if closeErr := lockFile.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return func() { removeLock(lockPath, token) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if attempt == leaseLockAttempts {
+			return nil, fmt.Errorf("lease file %s is locked by another replica", store.path)
+		}
+		if breakStaleLock(lockPath, token, duration) {
+			continue
+		}
+		time.Sleep(leaseLockInterval)
+	}
+}
+
+// breakStaleLock removes a lock older than the lease duration, left by a replica that crashed while acquiring.
+// The lock is moved away atomically and compared with the stale one, a lock another replica created meanwhile is put back.
+// It returns if a lock was moved.
+func breakStaleLock(lockPath string, token string, duration time.Duration) bool {
+	// Read before checking its age, a lock replaced in between is seen as fresh
+	stale, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(lockPath)
+	if err != nil || time.Since(info.ModTime()) <= duration {
+		return false
+	}
+
+	takenPath := lockPath + "." + token
+	if err := os.Rename(lockPath, takenPath); err != nil {
+		return false
+	}
+	defer os.Remove(takenPath)
+	if taken, err := ioutil.ReadFile(takenPath); err != nil || !bytes.Equal(taken, stale) {
+		os.Link(takenPath, lockPath)
+	}
+	return true
+}
+
+// removeLock removes the lock file when it is still the one created with token
+func removeLock(lockPath string, token string) {
+	if content, err := ioutil.ReadFile(lockPath); err == nil && string(content) == token {
+		os.Remove(lockPath)
+	}
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func (store *FileLeaseStore) read() (*lease, error) {
+	content, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	current := &lease{}
+	if err := json.Unmarshal(content, current); err != nil {
+		// A corrupted lease is free
+		return nil, nil
+	}
+	return current, nil
+}
+
+func (store *FileLeaseStore) write(newLease *lease) error {
+	content, err := json.Marshal(newLease)
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(content); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), store.path)
+}
+
+// LeaderElection elects a single controller replica to push configurations to servers.
+// Followers keep generating configurations, ready to take over when the leader lease expires.
+type LeaderElection struct {
+	store     LeaseStore
+	holder    string
+	duration  time.Duration
+	onElected func()
+	mutex     sync.RWMutex
+	expires   time.Time
+}
+
+// CreateLeaderElection creates a leader election for holder, calling onElected whenever it becomes the leader
+func CreateLeaderElection(store LeaseStore, holder string, duration time.Duration, onElected func()) *LeaderElection {
+	return &LeaderElection{
+		store:     store,
+		holder:    holder,
+		duration:  duration,
+		onElected: onElected,
+	}
+}
+
+// Start acquires or renews the lease three times per lease duration
+func (election *LeaderElection) Start() {
+	election.renew()
+	go func() {
+		for range time.Tick(election.duration / 3) {
+			election.renew()
+		}
+	}()
+}
+
+// IsLeader checks if this replica holds an unexpired lease
+func (election *LeaderElection) IsLeader() bool {
+	election.mutex.RLock()
+	defer election.mutex.RUnlock()
+	return time.Now().Before(election.expires)
+}
+
+func (election *LeaderElection) renew() {
+	log := logger()
+	start := time.Now()
+	acquired, err := election.store.Acquire(election.holder, election.duration)
+	if err != nil {
+		log.Error("Failed to acquire leader lease", zap.String("holder", election.holder), zap.Error(err))
+	}
+
+	wasLeader := election.IsLeader()
+
+	election.mutex.Lock()
+	if acquired {
+		// Measured from before acquiring, the lease expires here no later than in the store
+		election.expires = start.Add(election.duration)
+	} else if err == nil {
+		election.expires = time.Time{}
+	}
+	election.mutex.Unlock()
+
+	isLeader := election.IsLeader()
+	if isLeader {
+		metrics.leader.Set(1)
+	} else {
+		metrics.leader.Set(0)
+	}
+
+	if isLeader && !wasLeader {
+		log.Info("Elected as leader", zap.String("holder", election.holder))
+		if election.onElected != nil {
+			election.onElected()
+		}
+	} else if !isLeader && wasLeader {
+		log.Warn("Lost leadership", zap.String("holder", election.holder))
+	}
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLeaseStore_Acquire(t *testing.T) {
+	store := CreateFileLeaseStore(filepath.Join(t.TempDir(), "lease"))
+
+	acquired, err := store.Acquire("a", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Holder renews its lease, others wait for it to expire
+	acquired, err = store.Acquire("a", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.Acquire("b", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	// Expired lease is free
+	acquired, err = store.Acquire("a", -time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.Acquire("b", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestFileLeaseStore_AcquireConcurrently(t *testing.T) {
+	store := CreateFileLeaseStore(filepath.Join(t.TempDir(), "lease"))
+
+	var wg sync.WaitGroup
+	acquired := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		holder := fmt.Sprintf("replica-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := store.Acquire(holder, time.Minute); err == nil && ok {
+				acquired <- holder
+			}
+		}()
+	}
+	wg.Wait()
+	close(acquired)
+
+	holders := []string{}
+	for holder := range acquired {
+		holders = append(holders, holder)
+	}
+	assert.Len(t, holders, 1)
+}
+
+func TestFileLeaseStore_StaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	store := CreateFileLeaseStore(path)
+
+	// Lock left by a replica that crashed while acquiring
+	assert.NoError(t, ioutil.WriteFile(path+".lock", []byte{}, 0600))
+	stale := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path+".lock", stale, stale))
+
+	acquired, err := store.Acquire("a", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	_, err = os.Stat(path + ".lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileLeaseStore_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	store := CreateFileLeaseStore(path)
+
+	// Another replica is reading or writing the lease
+	assert.NoError(t, ioutil.WriteFile(path+".lock", []byte{}, 0600))
+
+	acquired, err := store.Acquire("a", time.Minute)
+	assert.Error(t, err)
+	assert.False(t, acquired)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileLeaseStore_BrokenLockIsKept(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	store := CreateFileLeaseStore(path)
+
+	unlock, err := store.lock(time.Minute)
+	assert.NoError(t, err)
+
+	// Lock was taken as stale by another replica, which created its own
+	assert.NoError(t, ioutil.WriteFile(path+".lock", []byte("other"), 0600))
+	unlock()
+	content, err := ioutil.ReadFile(path + ".lock")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(content))
+}
+
+func TestFileLeaseStore_FreshLockIsNotBroken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	assert.NoError(t, ioutil.WriteFile(path+".lock", []byte("other"), 0600))
+
+	assert.False(t, breakStaleLock(path+".lock", "token", time.Minute))
+	content, err := ioutil.ReadFile(path + ".lock")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(content))
+}
@@ -0,0 +1,210 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+
+	"gopkg.in/yaml.v2"
+)
+
+// lintResource is a container or service whose labels are linted
+type lintResource struct {
+	name         string
+	labels       map[string]string
+	templateData interface{}
+}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Labels composeLabels `yaml:"labels"`
+	Deploy struct {
+		Labels composeLabels `yaml:"labels"`
+	} `yaml:"deploy"`
+}
+
+// composeLabels accepts labels in both compose formats, a map or a list of key=value
+type composeLabels map[string]string
+
+func (labels *composeLabels) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	result := composeLabels{}
+
+	var labelsMap map[string]interface{}
+	if err := unmarshal(&labelsMap); err == nil {
+		for key, value := range labelsMap {
+			if value == nil {
+				result[key] = ""
+			} else {
+				result[key] = fmt.Sprintf("%v", value)
+			}
+		}
+		*labels = result
+		return nil
+	}
+
+	var labelsList []string
+	if err := unmarshal(&labelsList); err != nil {
+		return err
+	}
+	for _, label := range labelsList {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		} else {
+			result[parts[0]] = ""
+		}
+	}
+	*labels = result
+	return nil
+}
+
+// cmdLint validates labels from docker-compose or docker inspect files without a docker host
+func cmdLint(flags caddycmd.Flags) (int, error) {
+	options := createOptions(flags)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("Usage: caddy docker-proxy lint <docker-compose or docker inspect files>")
+	}
+
+	errorsCount := 0
+	for _, file := range files {
+		resources, err := readLintResources(file)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("Failed to read %s: %w", file, err)
+		}
+		for _, resource := range resources {
+			if lintError := generator.LintLabels(options.LabelPrefix, resource.labels, resource.templateData); lintError != nil {
+				errorsCount++
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", file, resource.name, lintError.Error())
+			}
+		}
+	}
+
+	if errorsCount > 0 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("%d resources have invalid labels", errorsCount)
+	}
+
+	return caddy.ExitCodeSuccess, nil
+}
+
+// readLintResources reads docker inspect JSON output or a docker-compose file
+func readLintResources(file string) ([]*lintResource, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var inspected []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &inspected); err == nil {
+		return readInspectResources(inspected)
+	}
+
+	return readComposeResources(data)
+}
+
+func readInspectResources(inspected []map[string]json.RawMessage) ([]*lintResource, error) {
+	resources := []*lintResource{}
+
+	for _, raw := range inspected {
+		rawJSON, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, isService := raw["Spec"]; isService {
+			service := swarm.Service{}
+			if err := json.Unmarshal(rawJSON, &service); err != nil {
+				return nil, err
+			}
+			resources = append(resources, &lintResource{
+				name:         "service " + service.Spec.Name,
+				labels:       service.Spec.Labels,
+				templateData: &service,
+			})
+			continue
+		}
+
+		containerJSON := types.ContainerJSON{}
+		if err := json.Unmarshal(rawJSON, &containerJSON); err != nil {
+			return nil, err
+		}
+		if containerJSON.ContainerJSONBase == nil || containerJSON.Config == nil {
+			continue
+		}
+		container := &types.Container{
+			ID:     containerJSON.ID,
+			Names:  []string{containerJSON.Name},
+			Image:  containerJSON.Config.Image,
+			Labels: containerJSON.Config.Labels,
+		}
+		resources = append(resources, &lintResource{
+			name:         "container " + strings.TrimPrefix(containerJSON.Name, "/"),
+			labels:       container.Labels,
+			templateData: generator.CreateContainerTemplateData(container),
+		})
+	}
+
+	return resources, nil
+}
+
+func readComposeResources(data []byte) ([]*lintResource, error) {
+	compose := composeFile{}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resources := []*lintResource{}
+	for _, name := range names {
+		composeService := compose.Services[name]
+		if len(composeService.Labels) > 0 {
+			resources = append(resources, &lintResource{
+				name:   "container " + name,
+				labels: composeService.Labels,
+				templateData: generator.CreateContainerTemplateData(&types.Container{
+					Names:  []string{"/" + name},
+					Labels: withComposeService(composeService.Labels, name),
+				}),
+			})
+		}
+		if len(composeService.Deploy.Labels) > 0 {
+			service := &swarm.Service{}
+			service.Spec.Name = name
+			service.Spec.Labels = composeService.Deploy.Labels
+			resources = append(resources, &lintResource{
+				name:         "service " + name,
+				labels:       composeService.Deploy.Labels,
+				templateData: service,
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+// withComposeService adds the label docker compose sets on containers of a service
+func withComposeService(labels map[string]string, service string) map[string]string {
+	withService := map[string]string{"com.docker.compose.service": service}
+	for label, value := range labels {
+		withService[label] = value
+	}
+	return withService
+}
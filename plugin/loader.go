@@ -3,10 +3,16 @@ package plugin
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
@@ -22,6 +28,15 @@ import (
 	"go.uber.org/zap"
 )
 
+// Label docker adds to containers of swarm tasks
+const swarmServiceIDLabel = "com.docker.swarm.service.id"
+
+// Signals of kill events that stop containers: SIGTERM and SIGKILL
+var drainSignals = map[string]bool{"15": true, "9": true}
+
+// Delay before the first retry of a failed server configuration
+const minRetryDelay = 1 * time.Second
+
 // DockerLoader generates caddy files from docker swarm information
 type DockerLoader struct {
 	options         *config.Options
@@ -30,19 +45,33 @@ type DockerLoader struct {
 	generator       *generator.CaddyfileGenerator
 	timer           *time.Timer
 	skipEvents      bool
+	lastReconcile   time.Time
+	updateMutex     sync.Mutex
+	lastMutex       sync.RWMutex
 	lastCaddyfile   []byte
 	lastJSONConfig  []byte
-	lastVersion     int64
-	serversVersions *StringInt64CMap
+	lastVersion     string
+	lastGeneration  *generator.Generation
+	serversVersions *StringStringCMap
 	serversUpdating *StringBoolCMap
+	serversRetries  *StringServerRetryCMap
+	history         *ConfigHistory
+	adminTLS        *AdminTLS
+	httpClient      *http.Client
+	rejections      *RejectionNotifier
+	leader          *LeaderElection
 }
 
 // CreateDockerLoader creates a docker loader
 func CreateDockerLoader(options *config.Options) *DockerLoader {
 	return &DockerLoader{
 		options:         options,
-		serversVersions: newStringInt64CMap(),
+		serversVersions: newStringStringCMap(),
 		serversUpdating: newStringBoolCMap(),
+		serversRetries:  newStringServerRetryCMap(),
+		history:         CreateConfigHistory(options.ConfigHistory),
+		httpClient:      http.DefaultClient,
+		rejections:      CreateRejectionNotifier(options.RejectionWebhook),
 	}
 }
 
@@ -57,22 +86,25 @@ func (dockerLoader *DockerLoader) Start() error {
 		dockerLoader.initialized = true
 		log := logger()
 
-		dockerClient, err := client.NewEnvClient()
+		adminTLS, err := LoadAdminTLS(dockerLoader.options, config.Controller)
 		if err != nil {
-			log.Error("Docker connection failed", zap.Error(err))
+			log.Error("Failed to load admin TLS", zap.Error(err))
 			return err
 		}
+		if adminTLS != nil {
+			dockerLoader.adminTLS = adminTLS
+			dockerLoader.httpClient = &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: adminTLS.ClientConfig(),
+				},
+			}
+		}
 
-		dockerPing, err := dockerClient.Ping(context.Background())
+		wrappedClient, err := connectDocker(log)
 		if err != nil {
-			log.Error("Docker ping failed", zap.Error(err))
 			return err
 		}
 
-		dockerClient.NegotiateAPIVersionPing(dockerPing)
-
-		wrappedClient := docker.WrapClient(dockerClient)
-
 		dockerLoader.dockerClient = wrappedClient
 		dockerLoader.generator = generator.CreateGenerator(
 			wrappedClient,
@@ -83,13 +115,42 @@ func (dockerLoader *DockerLoader) Start() error {
 		log.Info(
 			"Start",
 			zap.String("CaddyfilePath", dockerLoader.options.CaddyfilePath),
+			zap.String("StaticContainersPath", dockerLoader.options.StaticContainersPath),
 			zap.String("LabelPrefix", dockerLoader.options.LabelPrefix),
 			zap.Duration("PollingInterval", dockerLoader.options.PollingInterval),
+			zap.Duration("DrainTimeout", dockerLoader.options.DrainTimeout),
 			zap.Bool("ProcessCaddyfile", dockerLoader.options.ProcessCaddyfile),
 			zap.Bool("ProxyServiceTasks", dockerLoader.options.ProxyServiceTasks),
 			zap.String("IngressNetworks", fmt.Sprintf("%v", dockerLoader.options.IngressNetworks)),
+			zap.Bool("Secret", dockerLoader.options.Secret != ""),
+			zap.Bool("AdminTLS", dockerLoader.adminTLS != nil),
+			zap.String("RejectionWebhook", dockerLoader.options.RejectionWebhook),
+			zap.String("LeaderLeasePath", dockerLoader.options.LeaderLeasePath),
+			zap.Int("ConfigHistory", dockerLoader.options.ConfigHistory),
 		)
 
+		if dockerLoader.options.LeaderLeasePath != "" {
+			holder, err := os.Hostname()
+			if err != nil {
+				log.Error("Failed to get hostname to identify leader", zap.Error(err))
+				return err
+			}
+			dockerLoader.leader = CreateLeaderElection(
+				CreateFileLeaseStore(dockerLoader.options.LeaderLeasePath),
+				holder,
+				dockerLoader.options.LeaderLeaseDuration,
+				dockerLoader.onElected,
+			)
+			dockerLoader.leader.Start()
+		}
+
+		if dockerLoader.options.StatusListen != "" {
+			if err := startStatusServer(dockerLoader, dockerLoader.options.StatusListen); err != nil {
+				log.Error("Failed to start status server", zap.Error(err))
+				return err
+			}
+		}
+
 		dockerLoader.timer = time.AfterFunc(0, func() {
 			dockerLoader.update()
 		})
@@ -100,6 +161,25 @@ func (dockerLoader *DockerLoader) Start() error {
 	return nil
 }
 
+// connectDocker connects to docker host defined by environment variables
+func connectDocker(log *zap.Logger) (docker.Client, error) {
+	dockerClient, err := client.NewEnvClient()
+	if err != nil {
+		log.Error("Docker connection failed", zap.Error(err))
+		return nil, err
+	}
+
+	dockerPing, err := dockerClient.Ping(context.Background())
+	if err != nil {
+		log.Error("Docker ping failed", zap.Error(err))
+		return nil, err
+	}
+
+	dockerClient.NegotiateAPIVersionPing(dockerPing)
+
+	return docker.WrapClient(dockerClient), nil
+}
+
 func (dockerLoader *DockerLoader) monitorEvents() {
 	for {
 		dockerLoader.listenEvents()
@@ -128,22 +208,42 @@ ListenEvents:
 	for {
 		select {
 		case event := <-eventsChan:
-			if dockerLoader.skipEvents {
-				continue
-			}
+			metrics.dockerEvents.WithLabelValues(event.Type, eventAction(event.Action)).Inc()
 
 			update := (event.Type == "container" && event.Action == "create") ||
 				(event.Type == "container" && event.Action == "start") ||
 				(event.Type == "container" && event.Action == "stop") ||
 				(event.Type == "container" && event.Action == "die") ||
 				(event.Type == "container" && event.Action == "destroy") ||
+				(event.Type == "container" && event.Action == "kill") ||
+				(event.Type == "container" && eventAction(event.Action) == "health_status") ||
 				(event.Type == "service" && event.Action == "create") ||
 				(event.Type == "service" && event.Action == "update") ||
 				(event.Type == "service" && event.Action == "remove") ||
 				(event.Type == "config" && event.Action == "create") ||
 				(event.Type == "config" && event.Action == "remove")
 
-			if update {
+			if !update {
+				continue
+			}
+
+			// Stopping containers are kept in upstreams while they drain,
+			// other signals like SIGHUP are commonly used to reload containers
+			if event.Type == "container" && event.Action == "kill" && drainSignals[event.Actor.Attributes["signal"]] {
+				dockerLoader.generator.Drain(event.Actor.ID)
+			}
+			// Restarted containers are not stopping anymore
+			if event.Type == "container" && event.Action == "start" {
+				dockerLoader.generator.CancelDrain(event.Actor.ID)
+			}
+
+			dockerLoader.generator.Invalidate(event.Type, event.Actor.ID)
+			// Containers of swarm tasks change service upstreams
+			if serviceID := event.Actor.Attributes[swarmServiceIDLabel]; event.Type == "container" && serviceID != "" {
+				dockerLoader.generator.Invalidate("service", serviceID)
+			}
+
+			if !dockerLoader.skipEvents {
 				dockerLoader.skipEvents = true
 				dockerLoader.timer.Reset(100 * time.Millisecond)
 			}
@@ -158,16 +258,53 @@ ListenEvents:
 }
 
 func (dockerLoader *DockerLoader) update() bool {
+	// Timer can fire again while an update is still running
+	dockerLoader.updateMutex.Lock()
+	defer dockerLoader.updateMutex.Unlock()
+
 	dockerLoader.timer.Reset(dockerLoader.options.PollingInterval)
 	dockerLoader.skipEvents = false
 
 	// Don't cache the logger more globally, it can change based on config reloads
 	log := logger()
-	caddyfile, controlledServers := dockerLoader.generator.GenerateCaddyfile(log)
+
+	dockerLoader.lastMutex.RLock()
+	lastGeneration := dockerLoader.lastGeneration
+	dockerLoader.lastMutex.RUnlock()
+	if lastGeneration != nil {
+		dockerLoader.checkDrains(lastGeneration.ControlledServers)
+	}
+
+	start := time.Now()
+	var generation *generator.Generation
+	// Reconcile with a full listing every polling interval, events only refresh resources they name
+	reconcile := time.Since(dockerLoader.lastReconcile) >= dockerLoader.options.PollingInterval
+	if reconcile {
+		dockerLoader.lastReconcile = start
+		generation = dockerLoader.generator.Generate(log)
+	} else {
+		generation = dockerLoader.generator.GenerateIncremental(log)
+	}
+	metrics.generations.Inc()
+	metrics.generationDuration.Observe(time.Since(start).Seconds())
+	metrics.removedBlocks.Add(float64(len(generation.RemovedBlocks)))
+
+	// Check in-flight requests of draining containers frequently
+	if len(dockerLoader.generator.Draining()) > 0 {
+		dockerLoader.timer.Reset(drainCheckInterval)
+	}
+	// Followers generate the same rejections, only the leader reports them
+	if dockerLoader.isLeader() {
+		dockerLoader.rejections.Notify(generation)
+	}
+	caddyfile, controlledServers := generation.Caddyfile, generation.ControlledServers
 
 	caddyfileChanged := !bytes.Equal(dockerLoader.lastCaddyfile, caddyfile)
 
+	dockerLoader.lastMutex.Lock()
 	dockerLoader.lastCaddyfile = caddyfile
+	dockerLoader.lastGeneration = generation
+	dockerLoader.lastMutex.Unlock()
 
 	if caddyfileChanged {
 		log.Info("New Caddyfile", zap.ByteString("caddyfile", caddyfile))
@@ -182,28 +319,55 @@ func (dockerLoader *DockerLoader) update() bool {
 
 		if err != nil {
 			log.Error("Failed to convert caddyfile into json config", zap.Error(err))
+			metrics.adaptFailures.Inc()
+			return false
+		}
+
+		version, err := configHash(configJSON)
+		if err != nil {
+			log.Error("Failed to hash json config", zap.Error(err))
 			return false
 		}
 
-		log.Info("New Config JSON", zap.ByteString("json", configJSON))
+		log.Info("New Config JSON", zap.String("version", version), zap.ByteString("json", configJSON))
 
-		dockerLoader.lastJSONConfig = configJSON
-		dockerLoader.lastVersion++
+		dockerLoader.history.Add(version, caddyfile, configJSON)
+		if dockerLoader.history.IsBad(version) {
+			log.Warn("Configuration was rejected by most servers before, it won't be pushed", zap.String("version", version))
+		}
+
+		dockerLoader.setVersion(version, configJSON)
+	}
+
+	// Forget servers that are not controlled anymore
+	for _, server := range dockerLoader.serversRetries.Keys() {
+		if !containsString(controlledServers, server) {
+			dockerLoader.forgetServer(server)
+		}
+	}
+	for server := range dockerLoader.serversVersions.ToMap() {
+		if !containsString(controlledServers, server) {
+			dockerLoader.forgetServer(server)
+		}
 	}
 
 	var wg sync.WaitGroup
 	for _, server := range controlledServers {
 		wg.Add(1)
-		go dockerLoader.updateServer(&wg, server)
+		go func(server string) {
+			defer wg.Done()
+			// Servers configuration is also verified when reconciling, it may have been changed by others
+			dockerLoader.updateServer(server, reconcile)
+		}(server)
 	}
 	wg.Wait()
 
 	return true
 }
 
-func (dockerLoader *DockerLoader) updateServer(wg *sync.WaitGroup, server string) {
-	defer wg.Done()
-
+// updateServer pushes the last configuration to a server. When verify is true,
+// servers that received it are checked and configured again if they drifted from it.
+func (dockerLoader *DockerLoader) updateServer(server string, verify bool) {
 	// Skip servers that are being updated already
 	if dockerLoader.serversUpdating.Get(server) {
 		return
@@ -213,51 +377,341 @@ func (dockerLoader *DockerLoader) updateServer(wg *sync.WaitGroup, server string
 	dockerLoader.serversUpdating.Set(server, true)
 	defer dockerLoader.serversUpdating.Delete(server)
 
-	version := dockerLoader.lastVersion
+	// Only the leader configures other servers, followers keep configuring their own
+	if server != "localhost" && !dockerLoader.isLeader() {
+		return
+	}
+
+	dockerLoader.lastMutex.RLock()
+	configJSON, version := dockerLoader.lastJSONConfig, dockerLoader.lastVersion
+	dockerLoader.lastMutex.RUnlock()
+
+	// Bad versions are not pushed, servers keep or get back the last good version instead
+	if dockerLoader.history.IsBad(version) {
+		lastGood := dockerLoader.history.LastGood()
+		if lastGood == nil {
+			dockerLoader.cancelRetry(server)
+			return
+		}
+		configJSON, version = lastGood.Config, lastGood.Version
+	}
 
-	// Skip servers that already have this version
-	if dockerLoader.serversVersions.Get(server) >= version {
+	// Skip servers that already have this version, unless verifying it
+	current := dockerLoader.serversVersions.Get(server)
+	if current == version && (!verify || version == "") {
 		return
 	}
 
 	log := logger()
-	log.Info("Sending configuration to", zap.String("server", server))
 
-	url := "http://" + server + ":2019/load"
-
-	postBody, err := addAdminListen(dockerLoader.lastJSONConfig, "tcp/"+server+":2019")
+	serverConfigJSON, err := dockerLoader.serverConfig(server, configJSON)
 	if err != nil {
 		log.Error("Failed to add admin listen to", zap.String("server", server), zap.Error(err))
+		metrics.serverConfigOutdated.WithLabelValues(server).Set(1)
 		return
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(postBody))
+	// Servers keep their configuration when the controller restarts, don't push it again
+	hasConfig, err := dockerLoader.serverHasConfig(server, serverConfigJSON)
 	if err != nil {
-		log.Error("Failed to create request to", zap.String("server", server), zap.Error(err))
+		log.Warn("Failed to get current configuration of", zap.String("server", server), zap.Error(err))
+		// Unreachable servers are verified again when reconciling
+		if current == version {
+			return
+		}
+	}
+	if hasConfig {
+		if current != version {
+			dockerLoader.cancelRetry(server)
+			dockerLoader.history.Accept(version)
+			dockerLoader.serversVersions.Set(server, version)
+			metrics.serverConfigOutdated.WithLabelValues(server).Set(0)
+			log.Info("Server already has configuration", zap.String("server", server), zap.String("version", version))
+		}
 		return
 	}
+
+	// Configuration was changed through server admin API, or lost by a server restart
+	if current == version {
+		log.Warn("Server configuration drifted", zap.String("server", server), zap.String("version", version))
+		metrics.serverDrifts.WithLabelValues(server).Inc()
+		dockerLoader.serversVersions.Delete(server)
+	}
+
+	start := time.Now()
+	err = dockerLoader.sendConfiguration(server, serverConfigJSON)
+	metrics.serverPushDuration.WithLabelValues(server).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.serverPushFailures.WithLabelValues(server).Inc()
+		metrics.serverConfigOutdated.WithLabelValues(server).Set(1)
+		if rejection, rejected := err.(*configRejection); rejected && dockerLoader.rejectVersion(version, server, rejection) {
+			dockerLoader.cancelRetry(server)
+			return
+		}
+		dockerLoader.scheduleRetry(server)
+		return
+	}
+
+	dockerLoader.cancelRetry(server)
+	dockerLoader.history.Accept(version)
+	dockerLoader.serversVersions.Set(server, version)
+	metrics.serverConfigOutdated.WithLabelValues(server).Set(0)
+
+	log.Info("Successfully configured", zap.String("server", server), zap.String("version", version))
+}
+
+// rejectVersion records that a server rejected a version, returning if most servers rejected it
+func (dockerLoader *DockerLoader) rejectVersion(version string, server string, rejection *configRejection) bool {
+	dockerLoader.lastMutex.RLock()
+	servers := 0
+	if dockerLoader.lastGeneration != nil {
+		servers = len(dockerLoader.lastGeneration.ControlledServers)
+	}
+	dockerLoader.lastMutex.RUnlock()
+
+	wasBad := dockerLoader.history.IsBad(version)
+	bad := dockerLoader.history.Reject(version, server, rejection.Error(), servers)
+	if bad && !wasBad {
+		logger().Error("Configuration rejected by most servers, it won't be pushed anymore", zap.String("version", version), zap.Int("servers", servers))
+		metrics.badConfigs.Inc()
+		// Servers that rejected it before stop retrying it as well, others keep retrying their own failures
+		if configVersion := dockerLoader.history.Get(version); configVersion != nil {
+			for rejectedBy := range configVersion.RejectedBy {
+				dockerLoader.cancelRetry(rejectedBy)
+			}
+		}
+	}
+	return bad
+}
+
+// Rollback makes a version of history the current configuration, pushing it to all servers again.
+// It stays current until docker resources generate a different configuration.
+func (dockerLoader *DockerLoader) Rollback(version string) error {
+	configVersion := dockerLoader.history.Get(version)
+	if configVersion == nil {
+		return fmt.Errorf("version %s is not in history", version)
+	}
+
+	// Rolling back to a bad version retries it
+	dockerLoader.history.Reset(version)
+	dockerLoader.setVersion(version, configVersion.Config)
+
+	logger().Warn("Rolled back configuration", zap.String("version", version))
+
+	if dockerLoader.timer != nil {
+		dockerLoader.timer.Reset(0)
+	}
+	return nil
+}
+
+func (dockerLoader *DockerLoader) setVersion(version string, configJSON []byte) {
+	dockerLoader.lastMutex.Lock()
+	defer dockerLoader.lastMutex.Unlock()
+
+	dockerLoader.lastJSONConfig = configJSON
+	dockerLoader.lastVersion = version
+	metrics.configVersion.Reset()
+	metrics.configVersion.WithLabelValues(version).Set(1)
+}
+
+// serverHasConfig checks if a server is running a configuration, comparing content hashes
+func (dockerLoader *DockerLoader) serverHasConfig(server string, configJSON []byte) (bool, error) {
+	expected, err := configHash(configJSON)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := dockerLoader.getServerConfig(server)
+	if err != nil {
+		return false, err
+	}
+
+	// Servers without configuration return null
+	currentHash, err := configHash(current)
+	if err != nil {
+		return false, nil
+	}
+
+	return currentHash == expected, nil
+}
+
+// getServerConfig gets the configuration a server is running
+func (dockerLoader *DockerLoader) getServerConfig(server string) ([]byte, error) {
+	req, err := dockerLoader.newAdminRequest("GET", server, "/config/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dockerLoader.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, bodyBytes)
+	}
+	return bodyBytes, nil
+}
+
+// isLeader checks if this controller pushes configurations to other servers
+func (dockerLoader *DockerLoader) isLeader() bool {
+	return dockerLoader.leader == nil || dockerLoader.leader.IsLeader()
+}
+
+// onElected pushes the last configuration to all servers, they may run configurations of the previous leader
+func (dockerLoader *DockerLoader) onElected() {
+	for server := range dockerLoader.serversVersions.ToMap() {
+		if server != "localhost" {
+			dockerLoader.serversVersions.Delete(server)
+		}
+	}
+	if dockerLoader.timer != nil {
+		dockerLoader.timer.Reset(0)
+	}
+}
+
+// scheduleRetry retries configuring a server with exponential backoff and jitter
+func (dockerLoader *DockerLoader) scheduleRetry(server string) {
+	attempts := 1
+	if retry := dockerLoader.serversRetries.Get(server); retry != nil {
+		retry.Timer.Stop()
+		attempts = retry.Attempts + 1
+	}
+
+	delay := retryDelay(attempts, dockerLoader.options.PollingInterval)
+
+	logger().Info("Retrying configuration of", zap.String("server", server), zap.Int("attempt", attempts), zap.Duration("delay", delay))
+
+	dockerLoader.serversRetries.Set(server, &ServerRetry{
+		Attempts: attempts,
+		Timer: time.AfterFunc(delay, func() {
+			dockerLoader.updateServer(server, false)
+		}),
+	})
+}
+
+func (dockerLoader *DockerLoader) forgetServer(server string) {
+	dockerLoader.cancelRetry(server)
+	dockerLoader.serversVersions.Delete(server)
+	deleteServerMetrics(server)
+}
+
+func (dockerLoader *DockerLoader) cancelRetry(server string) {
+	if retry := dockerLoader.serversRetries.Get(server); retry != nil {
+		retry.Timer.Stop()
+		dockerLoader.serversRetries.Delete(server)
+	}
+}
+
+// retryDelay doubles for each attempt, up to maxDelay, randomized between half and full delay
+func retryDelay(attempts int, maxDelay time.Duration) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// serverConfig gets the configuration of a server, keeping its admin endpoint reachable by the controller
+func (dockerLoader *DockerLoader) serverConfig(server string, configJSON []byte) ([]byte, error) {
+	// Servers using a secret or TLS keep caddy admin local, behind an authenticating proxy
+	adminListen := "tcp/" + net.JoinHostPort(server, "2019")
+	if adminIsProxied(dockerLoader.options) {
+		adminListen = localAdminListen
+	}
+
+	return addAdminListen(configJSON, adminListen)
+}
+
+// configRejection is the error of a configuration push caddy failed to load.
+// Other errors, like admin proxy authentication or unavailable servers, are retried without rejecting the configuration.
+type configRejection struct {
+	statusCode int
+	body       []byte
+}
+
+func (rejection *configRejection) Error() string {
+	return fmt.Sprintf("status code %d: %s", rejection.statusCode, bytes.TrimSpace(rejection.body))
+}
+
+func (dockerLoader *DockerLoader) sendConfiguration(server string, postBody []byte) error {
+	log := logger()
+	log.Info("Sending configuration to", zap.String("server", server))
+
+	req, err := dockerLoader.newAdminRequest("POST", server, "/load", postBody)
+	if err != nil {
+		log.Error("Failed to create request to", zap.String("server", server), zap.Error(err))
+		return err
+	}
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := dockerLoader.httpClient.Do(req)
 
 	if err != nil {
 		log.Error("Failed to send configuration to", zap.String("server", server), zap.Error(err))
-		return
+		return err
 	}
+	defer resp.Body.Close()
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Error("Failed to read response from", zap.String("server", server), zap.Error(err))
-		return
+		return err
 	}
 
 	if resp.StatusCode != 200 {
 		log.Error("Error response from server", zap.String("server", server), zap.Int("status code", resp.StatusCode), zap.ByteString("body", bodyBytes))
-		return
+		if isLoadError(resp.StatusCode, bodyBytes) {
+			return &configRejection{statusCode: resp.StatusCode, body: bodyBytes}
+		}
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, bytes.TrimSpace(bodyBytes))
 	}
 
-	dockerLoader.serversVersions.Set(server, version)
+	return nil
+}
 
-	log.Info("Successfully configured", zap.String("server", server))
+// isLoadError checks if a response is caddy rejecting a configuration, like:
+// {"error":"loading new config: ..."}
+func isLoadError(statusCode int, body []byte) bool {
+	if statusCode != http.StatusBadRequest {
+		return false
+	}
+	apiError := struct {
+		Error string `json:"error"`
+	}{}
+	return json.Unmarshal(body, &apiError) == nil && apiError.Error != ""
+}
+
+// newAdminRequest creates a request to caddy admin endpoint of a server, signed when a secret is defined
+func (dockerLoader *DockerLoader) newAdminRequest(method string, server string, path string, body []byte) (*http.Request, error) {
+	// Local server is reached directly, remote servers through admin proxy TLS when enabled
+	scheme := "http"
+	if dockerLoader.adminTLS != nil && server != "localhost" {
+		scheme = "https"
+	}
+	url := scheme + "://" + net.JoinHostPort(server, "2019") + path
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if dockerLoader.options.Secret != "" {
+		if err := signRequest(req, body, dockerLoader.options.Secret); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
 }
 
 func addAdminListen(configJSON []byte, listen string) ([]byte, error) {
@@ -271,3 +725,30 @@ func addAdminListen(configJSON []byte, listen string) ([]byte, error) {
 	}
 	return json.Marshal(config)
 }
+
+// configHash hashes the content of a json config, ignoring formatting and keys order
+func configHash(configJSON []byte) (string, error) {
+	// Caddy admin API returns configurations re-encoded like this
+	var content interface{}
+	if err := json.Unmarshal(configJSON, &content); err != nil {
+		return "", err
+	}
+	if content == nil {
+		return "", errors.New("empty config")
+	}
+	canonical, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/config"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/docker"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCaddy fakes caddy admin endpoints of a controlled server
+type fakeCaddy struct {
+	mutex  sync.Mutex
+	config []byte
+	loads  int
+	// Statuses of the next loads, successful when empty
+	loadStatuses []int
+	upstreams    []upstreamStatus
+}
+
+func (server *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/config/":
+		if server.config == nil {
+			w.Write([]byte("null\n"))
+			return
+		}
+		w.Write(server.config)
+	case r.Method == http.MethodPost && r.URL.Path == "/load":
+		server.loads++
+		if len(server.loadStatuses) > 0 {
+			status := server.loadStatuses[0]
+			server.loadStatuses = server.loadStatuses[1:]
+			w.WriteHeader(status)
+			if status == http.StatusBadRequest {
+				w.Write([]byte(`{"error":"loading new config: invalid"}`))
+			} else {
+				w.Write([]byte(http.StatusText(status)))
+			}
+			return
+		}
+		server.config, _ = ioutil.ReadAll(r.Body)
+	case r.Method == http.MethodGet && r.URL.Path == "/reverse_proxy/upstreams":
+		json.NewEncoder(w).Encode(server.upstreams)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (server *fakeCaddy) getLoads() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.loads
+}
+
+func (server *fakeCaddy) setConfig(configJSON []byte) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.config = configJSON
+}
+
+// createTestLoader creates a loader reaching fake servers by address, like 10.0.0.1
+func createTestLoader(t *testing.T, servers map[string]*fakeCaddy) *DockerLoader {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.Host)
+		if server, exists := servers[host]; exists {
+			server.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "Unknown server", http.StatusBadGateway)
+	}))
+	t.Cleanup(httpServer.Close)
+
+	dockerLoader := CreateDockerLoader(&config.Options{
+		PollingInterval: time.Minute,
+		ConfigHistory:   10,
+	})
+	dockerLoader.httpClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, httpServer.Listener.Addr().String())
+			},
+		},
+	}
+	t.Cleanup(func() {
+		for _, server := range dockerLoader.serversRetries.Keys() {
+			dockerLoader.cancelRetry(server)
+		}
+	})
+	return dockerLoader
+}
+
+// setTestVersion makes a config the last generated version, controlling servers
+func setTestVersion(t *testing.T, dockerLoader *DockerLoader, configJSON []byte, servers ...string) string {
+	version, err := configHash(configJSON)
+	assert.NoError(t, err)
+	dockerLoader.history.Add(version, []byte{}, configJSON)
+	dockerLoader.setVersion(version, configJSON)
+	dockerLoader.lastMutex.Lock()
+	dockerLoader.lastGeneration = &generator.Generation{ControlledServers: servers}
+	dockerLoader.lastMutex.Unlock()
+	return version
+}
+
+func TestLoader_RejectedConfig(t *testing.T) {
+	server := &fakeCaddy{loadStatuses: []int{http.StatusBadRequest}}
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{"10.0.0.1": server})
+	version := setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+
+	dockerLoader.updateServer("10.0.0.1", false)
+	assert.True(t, dockerLoader.history.IsBad(version))
+	assert.Nil(t, dockerLoader.serversRetries.Get("10.0.0.1"))
+}
+
+func TestLoader_FailedPushIsNotRejection(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		server := &fakeCaddy{loadStatuses: []int{status}}
+		dockerLoader := createTestLoader(t, map[string]*fakeCaddy{"10.0.0.1": server})
+		version := setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+
+		// Admin proxy authentication and unavailable servers are retried
+		dockerLoader.updateServer("10.0.0.1", false)
+		assert.False(t, dockerLoader.history.IsBad(version), "status %d", status)
+		assert.Empty(t, dockerLoader.history.Get(version).RejectedBy, "status %d", status)
+		assert.NotNil(t, dockerLoader.serversRetries.Get("10.0.0.1"), "status %d", status)
+	}
+}
+
+func TestLoader_BadConfigKeepsOtherRetries(t *testing.T) {
+	servers := map[string]*fakeCaddy{
+		"10.0.0.1": {loadStatuses: []int{http.StatusBadRequest}},
+		"10.0.0.2": {loadStatuses: []int{http.StatusBadRequest}},
+		"10.0.0.3": {loadStatuses: []int{http.StatusServiceUnavailable}},
+	}
+	dockerLoader := createTestLoader(t, servers)
+	version := setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	dockerLoader.updateServer("10.0.0.3", false)
+	dockerLoader.updateServer("10.0.0.1", false)
+	assert.NotNil(t, dockerLoader.serversRetries.Get("10.0.0.1"))
+	dockerLoader.updateServer("10.0.0.2", false)
+
+	// Servers that rejected the bad version stop retrying, the unavailable one keeps retrying
+	assert.True(t, dockerLoader.history.IsBad(version))
+	assert.Nil(t, dockerLoader.serversRetries.Get("10.0.0.1"))
+	assert.Nil(t, dockerLoader.serversRetries.Get("10.0.0.2"))
+	assert.NotNil(t, dockerLoader.serversRetries.Get("10.0.0.3"))
+}
+
+func TestLoader_LastGoodConfig(t *testing.T) {
+	server := &fakeCaddy{}
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{"10.0.0.1": server})
+	good := setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+	dockerLoader.updateServer("10.0.0.1", false)
+	assert.Equal(t, good, dockerLoader.serversVersions.Get("10.0.0.1"))
+
+	server.mutex.Lock()
+	server.loadStatuses = []int{http.StatusBadRequest}
+	server.mutex.Unlock()
+	bad := setTestVersion(t, dockerLoader, []byte(`{"apps":{"tls":{}}}`), "10.0.0.1")
+	dockerLoader.updateServer("10.0.0.1", false)
+	assert.True(t, dockerLoader.history.IsBad(bad))
+
+	// Restarted server without configuration gets the last good version while the newest is bad
+	server.setConfig(nil)
+	dockerLoader.updateServer("10.0.0.1", true)
+	assert.Equal(t, good, dockerLoader.serversVersions.Get("10.0.0.1"))
+	assert.Equal(t, 3, server.getLoads())
+}
+
+func TestLoader_ActiveRequests(t *testing.T) {
+	upstreams := []upstreamStatus{
+		{Address: "172.18.0.1:8080", NumRequests: 2},
+		{Address: "172.18.0.1:9090", NumRequests: 3},
+		{Address: "[fd00::2]:80", NumRequests: 1},
+	}
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{
+		"10.0.0.1": {upstreams: upstreams},
+		"10.0.0.2": {upstreams: upstreams},
+	})
+
+	activeRequests, err := dockerLoader.getActiveRequests([]string{"10.0.0.1", "10.0.0.2"})
+	assert.NoError(t, err)
+	// Host network containers share the gateway host and are told apart by port
+	assert.Equal(t, 4, activeRequests["172.18.0.1:8080"])
+	assert.Equal(t, 6, activeRequests["172.18.0.1:9090"])
+	assert.Equal(t, 10, activeRequests["172.18.0.1"])
+	assert.Equal(t, 2, activeRequests["fd00::2"])
+}
+
+func TestConfigHash_KeyOrder(t *testing.T) {
+	hash, err := configHash([]byte(`{"apps":{"http":{"servers":{}},"tls":{}},"admin":{"listen":"localhost:2019"}}`))
+	assert.NoError(t, err)
+
+	// Caddy admin API returns configurations re-encoded, with a different key order and spacing
+	reordered, err := configHash([]byte("{\n  \"admin\": {\"listen\": \"localhost:2019\"},\n  \"apps\": {\"tls\": {}, \"http\": {\"servers\": {}}}\n}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, reordered)
+
+	changed, err := configHash([]byte(`{"apps":{"http":{"servers":{}}},"admin":{"listen":"localhost:2019"}}`))
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+
+	_, err = configHash([]byte("null\n"))
+	assert.Error(t, err)
+}
+
+func TestLoader_UpdateSkipsSameVersion(t *testing.T) {
+	server := &fakeCaddy{}
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{"localhost": server})
+	dockerLoader.options.Mode = config.Server
+	dockerLoader.options.LabelPrefix = generator.DefaultLabelPrefix
+	dockerLoader.options.IngressNetworks = []string{"caddy"}
+	dockerLoader.generator = generator.CreateGenerator(&docker.ClientMock{
+		ContainersData: []types.Container{{
+			ID: "A",
+			Labels: map[string]string{
+				"caddy":         "example.com",
+				"caddy.respond": "200",
+			},
+		}},
+	}, &docker.UtilsMock{}, dockerLoader.options)
+	dockerLoader.timer = time.AfterFunc(time.Hour, func() {})
+	t.Cleanup(func() { dockerLoader.timer.Stop() })
+
+	assert.True(t, dockerLoader.update())
+	assert.Equal(t, 1, server.getLoads())
+	version := dockerLoader.serversVersions.Get("localhost")
+	assert.NotEmpty(t, version)
+
+	// Same configuration is not pushed again
+	assert.True(t, dockerLoader.update())
+	assert.Equal(t, 1, server.getLoads())
+
+	// Nor when reconciling with a server returning it re-encoded
+	server.mutex.Lock()
+	var content interface{}
+	assert.NoError(t, json.Unmarshal(server.config, &content))
+	server.config, _ = json.MarshalIndent(content, "", "  ")
+	server.mutex.Unlock()
+	dockerLoader.lastReconcile = time.Time{}
+	assert.True(t, dockerLoader.update())
+	assert.Equal(t, 1, server.getLoads())
+	assert.Equal(t, version, dockerLoader.serversVersions.Get("localhost"))
+}
+
+func TestLoader_ServerDrift(t *testing.T) {
+	servers := map[string]*fakeCaddy{
+		"10.0.0.1": {},
+		"10.0.0.2": {},
+	}
+	dockerLoader := createTestLoader(t, servers)
+	setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1", "10.0.0.2")
+	dockerLoader.updateServer("10.0.0.1", false)
+	dockerLoader.updateServer("10.0.0.2", false)
+
+	// Configuration of one server is changed through its admin API
+	servers["10.0.0.1"].setConfig([]byte(`{"apps":{"tls":{}}}`))
+	drifts := testutil.ToFloat64(metrics.serverDrifts.WithLabelValues("10.0.0.1"))
+	otherDrifts := testutil.ToFloat64(metrics.serverDrifts.WithLabelValues("10.0.0.2"))
+
+	dockerLoader.updateServer("10.0.0.1", true)
+	dockerLoader.updateServer("10.0.0.2", true)
+
+	assert.Equal(t, 2, servers["10.0.0.1"].getLoads())
+	assert.Equal(t, drifts+1, testutil.ToFloat64(metrics.serverDrifts.WithLabelValues("10.0.0.1")))
+	assert.Equal(t, 1, servers["10.0.0.2"].getLoads())
+	assert.Equal(t, otherDrifts, testutil.ToFloat64(metrics.serverDrifts.WithLabelValues("10.0.0.2")))
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		maxDelay time.Duration
+		min      time.Duration
+		max      time.Duration
+	}{
+		{attempts: 1, maxDelay: time.Minute, min: 500 * time.Millisecond, max: time.Second},
+		{attempts: 2, maxDelay: time.Minute, min: time.Second, max: 2 * time.Second},
+		{attempts: 4, maxDelay: time.Minute, min: 4 * time.Second, max: 8 * time.Second},
+		{attempts: 10, maxDelay: 5 * time.Second, min: 2500 * time.Millisecond, max: 5 * time.Second},
+		{attempts: 100, maxDelay: time.Minute, min: 30 * time.Second, max: time.Minute},
+		{attempts: 1, maxDelay: 200 * time.Millisecond, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			delay := retryDelay(test.attempts, test.maxDelay)
+			assert.GreaterOrEqual(t, int64(delay), int64(test.min), "attempts %d, max delay %s", test.attempts, test.maxDelay)
+			assert.LessOrEqual(t, int64(delay), int64(test.max), "attempts %d, max delay %s", test.attempts, test.maxDelay)
+		}
+	}
+}
+
+func TestLoader_RetrySucceeds(t *testing.T) {
+	server := &fakeCaddy{loadStatuses: []int{http.StatusServiceUnavailable}}
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{"10.0.0.1": server})
+	version := setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+
+	dockerLoader.updateServer("10.0.0.1", false)
+	assert.NotNil(t, dockerLoader.serversRetries.Get("10.0.0.1"))
+	assert.Equal(t, "", dockerLoader.serversVersions.Get("10.0.0.1"))
+
+	// First retry is scheduled within a second
+	assert.Eventually(t, func() bool {
+		return dockerLoader.serversVersions.Get("10.0.0.1") == version
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 2, server.getLoads())
+	assert.Nil(t, dockerLoader.serversRetries.Get("10.0.0.1"))
+}
+
+func TestLoader_NewerVersionCancelsRetry(t *testing.T) {
+	server := &fakeCaddy{loadStatuses: []int{http.StatusServiceUnavailable}}
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{"10.0.0.1": server})
+	setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+
+	dockerLoader.updateServer("10.0.0.1", false)
+	retry := dockerLoader.serversRetries.Get("10.0.0.1")
+	assert.NotNil(t, retry)
+
+	// Newer version is pushed before the retry, which is not needed anymore
+	newer := setTestVersion(t, dockerLoader, []byte(`{"apps":{"tls":{}}}`), "10.0.0.1")
+	dockerLoader.updateServer("10.0.0.1", false)
+	assert.Equal(t, newer, dockerLoader.serversVersions.Get("10.0.0.1"))
+	assert.Nil(t, dockerLoader.serversRetries.Get("10.0.0.1"))
+	assert.False(t, retry.Timer.Stop(), "retry timer should be stopped")
+	assert.Equal(t, 2, server.getLoads())
+}
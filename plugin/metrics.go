@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "caddy"
+const metricsSubsystem = "docker_proxy"
+
+var metrics = struct {
+	generations          prometheus.Counter
+	generationDuration   prometheus.Histogram
+	removedBlocks        prometheus.Counter
+	adaptFailures        prometheus.Counter
+	badConfigs           prometheus.Counter
+	configVersion        *prometheus.GaugeVec
+	dockerEvents         *prometheus.CounterVec
+	serverPushDuration   *prometheus.HistogramVec
+	serverPushFailures   *prometheus.CounterVec
+	serverConfigOutdated *prometheus.GaugeVec
+	serverDrifts         *prometheus.CounterVec
+	leader               prometheus.Gauge
+}{
+	generations: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "generations_total",
+		Help:      "Number of caddyfile generations from docker metadata.",
+	}),
+	generationDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "generation_duration_seconds",
+		Help:      "Duration of caddyfile generations from docker metadata.",
+		Buckets:   prometheus.DefBuckets,
+	}),
+	removedBlocks: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "process_removed_blocks_total",
+		Help:      "Number of invalid blocks removed while processing generated caddyfiles.",
+	}),
+	adaptFailures: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "adapt_failures_total",
+		Help:      "Number of generated caddyfiles that failed to be adapted into json config.",
+	}),
+	badConfigs: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "bad_configs_total",
+		Help:      "Number of generated json configs rejected by most controlled servers.",
+	}),
+	configVersion: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "config_version_info",
+		Help:      "Content hash of the last generated json config, as version label.",
+	}, []string{"version"}),
+	dockerEvents: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "docker_events_total",
+		Help:      "Number of docker events received.",
+	}, []string{"type", "action"}),
+	serverPushDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "server_push_duration_seconds",
+		Help:      "Duration of configuration pushes to controlled servers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server"}),
+	serverPushFailures: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "server_push_failures_total",
+		Help:      "Number of failed configuration pushes to controlled servers.",
+	}, []string{"server"}),
+	serverConfigOutdated: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "server_config_outdated",
+		Help:      "Whether a controlled server failed to receive the last generated json config.",
+	}, []string{"server"}),
+	serverDrifts: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "server_drifts_total",
+		Help:      "Number of times a controlled server was found running a different config than the one it received.",
+	}, []string{"server"}),
+	leader: promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "leader",
+		Help:      "Whether this controller is the elected leader pushing configurations, when leader election is enabled.",
+	}),
+}
+
+// eventAction removes the parameters some docker events add to their action, like "exec_start: sh"
+func eventAction(action string) string {
+	return strings.SplitN(action, ":", 2)[0]
+}
+
+// deleteServerMetrics stops reporting metrics of a server that is not controlled anymore
+func deleteServerMetrics(server string) {
+	metrics.serverPushDuration.DeleteLabelValues(server)
+	metrics.serverPushFailures.DeleteLabelValues(server)
+	metrics.serverConfigOutdated.DeleteLabelValues(server)
+	metrics.serverDrifts.DeleteLabelValues(server)
+}
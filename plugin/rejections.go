@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+
+	"go.uber.org/zap"
+)
+
+// Timeout of rejection webhook requests
+const rejectionWebhookTimeout = 10 * time.Second
+
+// Rejection describes labels of a resource that generated a block caddy failed to adapt
+type Rejection struct {
+	Kind   string   `json:"kind"`
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+	Error  string   `json:"error"`
+	Block  string   `json:"block"`
+}
+
+// RejectionNotifier reports rejections to logs and to an optional webhook,
+// only once while a resource keeps generating the same invalid block
+type RejectionNotifier struct {
+	webhook    string
+	httpClient *http.Client
+	mutex      sync.Mutex
+	notified   map[string]bool
+}
+
+// CreateRejectionNotifier creates a rejection notifier posting to webhook, when it is not empty
+func CreateRejectionNotifier(webhook string) *RejectionNotifier {
+	return &RejectionNotifier{
+		webhook:    webhook,
+		httpClient: &http.Client{Timeout: rejectionWebhookTimeout},
+		notified:   map[string]bool{},
+	}
+}
+
+// Notify reports rejections of a generation that weren't reported by the previous one
+func (notifier *RejectionNotifier) Notify(generation *generator.Generation) {
+	log := logger()
+
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	notified := map[string]bool{}
+	for _, rejection := range getRejections(generation) {
+		key := rejection.key()
+		notified[key] = true
+		if notifier.notified[key] {
+			continue
+		}
+
+		log.Warn("Rejected labels",
+			zap.String("kind", rejection.Kind),
+			zap.String("id", rejection.ID),
+			zap.String("name", rejection.Name),
+			zap.Strings("labels", rejection.Labels),
+			zap.String("error", rejection.Error),
+		)
+
+		if notifier.webhook != "" {
+			go notifier.post(rejection)
+		}
+	}
+	notifier.notified = notified
+}
+
+func (notifier *RejectionNotifier) post(rejection *Rejection) {
+	log := logger()
+
+	body, err := json.Marshal(rejection)
+	if err != nil {
+		log.Error("Failed to serialize rejection", zap.Error(err))
+		return
+	}
+
+	resp, err := notifier.httpClient.Post(notifier.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Error("Failed to post rejection to webhook", zap.String("webhook", notifier.webhook), zap.String("id", rejection.ID), zap.Error(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Error("Rejection webhook returned an error", zap.String("webhook", notifier.webhook), zap.String("id", rejection.ID), zap.Int("status", resp.StatusCode))
+	}
+}
+
+// getRejections splits removed blocks by the resources that generated them
+func getRejections(generation *generator.Generation) []*Rejection {
+	rejections := []*Rejection{}
+	for _, removedBlock := range generation.RemovedBlocks {
+		byResource := map[string]*Rejection{}
+		for _, provenance := range removedBlock.Provenance {
+			resourceKey := provenance.Kind + "/" + provenance.ID + "/" + provenance.Name
+			rejection, exists := byResource[resourceKey]
+			if !exists {
+				rejection = &Rejection{
+					Kind:   provenance.Kind,
+					ID:     provenance.ID,
+					Name:   provenance.Name,
+					Labels: []string{},
+					Error:  removedBlock.Error,
+					Block:  removedBlock.Block,
+				}
+				byResource[resourceKey] = rejection
+				rejections = append(rejections, rejection)
+			}
+			if provenance.Label != "" {
+				rejection.Labels = append(rejection.Labels, provenance.Label)
+			}
+		}
+	}
+	return rejections
+}
+
+func (rejection *Rejection) key() string {
+	// Errors are not part of the key, their line numbers change with other blocks
+	return fmt.Sprintf("%s/%s/%s\n%s", rejection.Kind, rejection.ID, strings.Join(rejection.Labels, ","), rejection.Block)
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/caddyfile"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+	"github.com/stretchr/testify/assert"
+)
+
+func createRejectedGeneration(label string) *generator.Generation {
+	return &generator.Generation{
+		RemovedBlocks: []generator.RemovedBlock{{
+			Block: "example.com {\n\tinvalid\n}\n",
+			Error: "unrecognized directive: invalid",
+			Provenance: []*caddyfile.Provenance{
+				{Kind: "container", ID: "A", Name: "service", Label: label},
+			},
+		}},
+	}
+}
+
+func TestRejectionNotifier_PostsOnce(t *testing.T) {
+	posted := make(chan *Rejection, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejection := &Rejection{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(rejection))
+		posted <- rejection
+	}))
+	defer webhook.Close()
+
+	notifier := CreateRejectionNotifier(webhook.URL)
+	receive := func() *Rejection {
+		select {
+		case rejection := <-posted:
+			return rejection
+		case <-time.After(5 * time.Second):
+			t.Fatal("rejection was not posted")
+			return nil
+		}
+	}
+
+	notifier.Notify(createRejectedGeneration("caddy.invalid"))
+	rejection := receive()
+	assert.Equal(t, "A", rejection.ID)
+	assert.Equal(t, []string{"caddy.invalid"}, rejection.Labels)
+
+	// Same rejection in the next generation is not posted again
+	notifier.Notify(createRejectedGeneration("caddy.invalid"))
+
+	// A different label is
+	notifier.Notify(createRejectedGeneration("caddy.other"))
+	rejection = receive()
+	assert.Equal(t, []string{"caddy.other"}, rejection.Labels)
+
+	select {
+	case rejection := <-posted:
+		t.Errorf("unexpected rejection posted: %v", rejection.Labels)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
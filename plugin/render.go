@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/docker"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// cmdRender generates the caddyfile once, prints it with its json config and exits
+func cmdRender(flags caddycmd.Flags) (int, error) {
+	options := createOptions(flags)
+
+	// Only warnings and errors, process logs are printed below
+	log := logger().WithOptions(zap.IncreaseLevel(zapcore.WarnLevel))
+
+	dockerClient, err := connectDocker(log)
+	if err != nil {
+		return 1, err
+	}
+
+	generation := generator.CreateGenerator(dockerClient, docker.CreateUtils(), options).Generate(log)
+
+	return renderGeneration(generation, os.Stdout, os.Stderr)
+}
+
+// renderGeneration prints a generated caddyfile with its json config,
+// failing when the caddyfile is invalid or invalid blocks were removed from it
+func renderGeneration(generation *generator.Generation, stdout io.Writer, stderr io.Writer) (int, error) {
+	fmt.Fprintf(stdout, "%s\n", generation.Caddyfile)
+
+	if len(generation.ProcessLogs) > 0 {
+		fmt.Fprintf(stderr, "%s", generation.ProcessLogs)
+	}
+
+	configJSON, warn, err := caddyconfig.GetAdapter("caddyfile").Adapt(generation.Caddyfile, nil)
+	for _, w := range warn {
+		fmt.Fprintf(stderr, "[WARNING] %s\n", w.String())
+	}
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("Failed to convert caddyfile into json config: %w", err)
+	}
+
+	var indentedJSON bytes.Buffer
+	if err := json.Indent(&indentedJSON, configJSON, "", "\t"); err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	fmt.Fprintf(stdout, "%s\n", indentedJSON.Bytes())
+
+	if len(generation.RemovedBlocks) > 0 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("%d invalid blocks were removed from caddyfile", len(generation.RemovedBlocks))
+	}
+
+	return caddy.ExitCodeSuccess, nil
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderGeneration_Success(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code, err := renderGeneration(&generator.Generation{
+		Caddyfile: []byte("example.com {\n\trespond 200\n}\n"),
+	}, &stdout, &stderr)
+
+	assert.NoError(t, err)
+	assert.Equal(t, caddy.ExitCodeSuccess, code)
+	assert.Contains(t, stdout.String(), "example.com {\n\trespond 200\n}\n")
+	assert.Contains(t, stdout.String(), `"static_response"`)
+	assert.Empty(t, stderr.String())
+}
+
+func TestRenderGeneration_RemovedBlocks(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code, err := renderGeneration(&generator.Generation{
+		Caddyfile:   []byte("example.com {\n\trespond 200\n}\n"),
+		ProcessLogs: []byte("[ERROR]  Removing invalid block: unrecognized directive: invalid\n"),
+		RemovedBlocks: []generator.RemovedBlock{{
+			Block: "other.com {\n\tinvalid\n}\n",
+			Error: "unrecognized directive: invalid",
+		}},
+	}, &stdout, &stderr)
+
+	// Valid blocks are still printed, but the command fails
+	assert.EqualError(t, err, "1 invalid blocks were removed from caddyfile")
+	assert.Equal(t, caddy.ExitCodeFailedStartup, code)
+	assert.Contains(t, stdout.String(), `"static_response"`)
+	assert.Contains(t, stderr.String(), "Removing invalid block")
+}
+
+func TestRenderGeneration_InvalidCaddyfile(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code, err := renderGeneration(&generator.Generation{
+		Caddyfile: []byte("example.com {\n\tinvalid\n}\n"),
+	}, &stdout, &stderr)
+
+	assert.Error(t, err)
+	assert.Equal(t, caddy.ExitCodeFailedStartup, code)
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const signatureHeader = "X-Caddy-Docker-Signature"
+const timestampHeader = "X-Caddy-Docker-Timestamp"
+const nonceHeader = "X-Caddy-Docker-Nonce"
+
+// Maximum accepted difference between controller and server clocks
+const maxSignatureAge = 5 * time.Minute
+
+// Signatures of verified requests, a request can't be received again while its timestamp is accepted
+var seenSignatures = newSignatureCache()
+
+// signRequest signs a request to a controlled server with the shared secret
+func signRequest(req *http.Request, body []byte, secret string) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	// Identical requests sent in the same second get different signatures
+	nonce, err := randomToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, computeSignature(secret, timestamp, nonce, req.Method, req.URL.RequestURI(), body))
+	return nil
+}
+
+// verifyRequest checks that a request was signed with the shared secret, and wasn't received before
+func verifyRequest(req *http.Request, body []byte, secret string) error {
+	timestamp := req.Header.Get(timestampHeader)
+	nonce := req.Header.Get(nonceHeader)
+	signature := req.Header.Get(signatureHeader)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return errors.New("Request is not signed")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Invalid signature timestamp")
+	}
+	signedAt := time.Unix(seconds, 0)
+	age := time.Since(signedAt)
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return errors.New("Signature timestamp is too far from current time")
+	}
+
+	expected := computeSignature(secret, timestamp, nonce, req.Method, req.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("Invalid signature")
+	}
+
+	if !seenSignatures.add(signature, signedAt.Add(maxSignatureAge)) {
+		return errors.New("Request was already received")
+	}
+
+	return nil
+}
+
+func computeSignature(secret string, timestamp string, nonce string, method string, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + method + "\n" + uri + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signatureCache keeps signatures until their timestamps are too old to be accepted
+type signatureCache struct {
+	mutex      sync.Mutex
+	signatures map[string]time.Time
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{
+		signatures: map[string]time.Time{},
+	}
+}
+
+// add adds a signature expiring at expiration, returning false if it was added before
+func (cache *signatureCache) add(signature string, expiration time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	for seen, seenExpiration := range cache.signatures {
+		if now.After(seenExpiration) {
+			delete(cache.signatures, seen)
+		}
+	}
+
+	if _, exists := cache.signatures[signature]; exists {
+		return false
+	}
+	cache.signatures[signature] = expiration
+	return true
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature_VerifyRequest(t *testing.T) {
+	const secret = "secret"
+	body := []byte(`{"apps":{}}`)
+
+	testCases := []struct {
+		name          string
+		secret        string
+		modify        func(req *http.Request) []byte
+		expectedError string
+	}{
+		{
+			name:   "valid signature",
+			secret: secret,
+		},
+		{
+			name:   "unsigned request",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				req.Header.Del(signatureHeader)
+				req.Header.Del(timestampHeader)
+				return body
+			},
+			expectedError: "Request is not signed",
+		},
+		{
+			name:   "missing nonce",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				req.Header.Del(nonceHeader)
+				return body
+			},
+			expectedError: "Request is not signed",
+		},
+		{
+			name:   "tampered nonce",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				req.Header.Set(nonceHeader, "other")
+				return body
+			},
+			expectedError: "Invalid signature",
+		},
+		{
+			name:          "different secret",
+			secret:        "other secret",
+			expectedError: "Invalid signature",
+		},
+		{
+			name:   "tampered body",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				return []byte(`{"apps":{"http":{}}}`)
+			},
+			expectedError: "Invalid signature",
+		},
+		{
+			name:   "tampered uri",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				req.URL.Path = "/config/apps"
+				return body
+			},
+			expectedError: "Invalid signature",
+		},
+		{
+			name:   "tampered method",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				req.Method = "PATCH"
+				return body
+			},
+			expectedError: "Invalid signature",
+		},
+		{
+			name:   "invalid timestamp",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				req.Header.Set(timestampHeader, "yesterday")
+				return body
+			},
+			expectedError: "Invalid signature timestamp",
+		},
+		{
+			name:   "old timestamp",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				signAt(req, body, secret, time.Now().Add(-maxSignatureAge-time.Minute))
+				return body
+			},
+			expectedError: "Signature timestamp is too far from current time",
+		},
+		{
+			name:   "future timestamp",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				signAt(req, body, secret, time.Now().Add(maxSignatureAge+time.Minute))
+				return body
+			},
+			expectedError: "Signature timestamp is too far from current time",
+		},
+		{
+			name:   "timestamp within clock skew",
+			secret: secret,
+			modify: func(req *http.Request) []byte {
+				signAt(req, body, secret, time.Now().Add(-maxSignatureAge+time.Minute))
+				return body
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://10.0.0.2:2019/load", bytes.NewReader(body))
+			assert.NoError(t, err)
+			assert.NoError(t, signRequest(req, body, secret))
+
+			received := body
+			if testCase.modify != nil {
+				received = testCase.modify(req)
+			}
+
+			err = verifyRequest(req, received, testCase.secret)
+			if testCase.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, testCase.expectedError)
+			}
+		})
+	}
+}
+
+func signAt(req *http.Request, body []byte, secret string, at time.Time) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	nonce := req.Header.Get(nonceHeader)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, computeSignature(secret, timestamp, nonce, req.Method, req.URL.RequestURI(), body))
+}
+
+func TestSignature_Replay(t *testing.T) {
+	const secret = "secret"
+	body := []byte(`{"apps":{}}`)
+
+	req, err := http.NewRequest("POST", "http://10.0.0.2:2019/load", bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.NoError(t, signRequest(req, body, secret))
+
+	assert.NoError(t, verifyRequest(req, body, secret))
+	assert.EqualError(t, verifyRequest(req, body, secret), "Request was already received")
+
+	// Same request signed again is not a replay
+	other, err := http.NewRequest("POST", "http://10.0.0.2:2019/load", bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.NoError(t, signRequest(other, body, secret))
+	assert.NoError(t, verifyRequest(other, body, secret))
+}
+
+func TestSignatureCache_Expiration(t *testing.T) {
+	cache := newSignatureCache()
+
+	assert.True(t, cache.add("a", time.Now().Add(-time.Second)))
+	assert.True(t, cache.add("b", time.Now().Add(time.Minute)))
+	assert.False(t, cache.add("b", time.Now().Add(time.Minute)))
+
+	// Expired signatures are removed, their timestamps are rejected as too old
+	assert.Len(t, cache.signatures, 1)
+	assert.True(t, cache.add("a", time.Now().Add(time.Minute)))
+}
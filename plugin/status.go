@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/lucaslorentz/caddy-docker-proxy/plugin/v2/generator"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.uber.org/zap"
+)
+
+// Status is the state generated by the controller, as exposed by the status API
+type Status struct {
+	Version       string                   `json:"version"`
+	Caddyfile     string                   `json:"caddyfile"`
+	Config        json.RawMessage          `json:"config"`
+	Servers       map[string]string        `json:"servers"`
+	Contributions []generator.Contribution `json:"contributions"`
+	RemovedBlocks []generator.RemovedBlock `json:"removedBlocks"`
+	Leader        bool                     `json:"leader"`
+}
+
+// Status returns a snapshot of the last generated state and servers acknowledged versions
+func (dockerLoader *DockerLoader) Status() *Status {
+	dockerLoader.lastMutex.RLock()
+	defer dockerLoader.lastMutex.RUnlock()
+
+	status := &Status{
+		Version:       dockerLoader.lastVersion,
+		Caddyfile:     string(dockerLoader.lastCaddyfile),
+		Config:        dockerLoader.lastJSONConfig,
+		Servers:       dockerLoader.serversVersions.ToMap(),
+		Contributions: []generator.Contribution{},
+		RemovedBlocks: []generator.RemovedBlock{},
+		Leader:        dockerLoader.isLeader(),
+	}
+	if dockerLoader.lastGeneration != nil {
+		status.Contributions = dockerLoader.lastGeneration.Contributions
+		status.RemovedBlocks = dockerLoader.lastGeneration.RemovedBlocks
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		logger().Error("Failed to write status", zap.Error(err))
+	}
+}
+
+func startStatusServer(dockerLoader *DockerLoader, listen string) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+
+	handler := createStatusHandler(dockerLoader)
+
+	logger().Info("Status server listening", zap.String("address", listen))
+
+	go func() {
+		err := http.Serve(listener, handler)
+		logger().Error("Status server stopped", zap.Error(err))
+	}()
+
+	return nil
+}
+
+func createStatusHandler(dockerLoader *DockerLoader) http.Handler {
+	mux := http.NewServeMux()
+
+	// Status and history include configurations, they are only served signed when a secret is defined
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if dockerLoader.options.Secret != "" && !verifyStatusRequest(w, r, dockerLoader.options.Secret) {
+			return
+		}
+		writeJSON(w, dockerLoader.Status())
+	})
+
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if dockerLoader.options.Secret != "" && !verifyStatusRequest(w, r, dockerLoader.options.Secret) {
+			return
+		}
+		writeJSON(w, dockerLoader.history.Versions())
+	})
+
+	// Rollbacks change servers configuration, they are only served signed like configuration pushes
+	if dockerLoader.options.Secret != "" {
+		mux.HandleFunc("/rollback", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !verifyStatusRequest(w, r, dockerLoader.options.Secret) {
+				return
+			}
+			if err := dockerLoader.Rollback(r.URL.Query().Get("version")); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	} else {
+		logger().Info("Rollback endpoint is disabled, it requires a secret to sign requests")
+	}
+
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return mux
+}
+
+// verifyStatusRequest checks that a status API request was signed with the secret, responding with an error when it wasn't
+func verifyStatusRequest(w http.ResponseWriter, r *http.Request, secret string) bool {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminRequestSize))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return false
+	}
+	if err := verifyRequest(r, body, secret); err != nil {
+		logger().Warn("Rejected status request", zap.String("remote", r.RemoteAddr), zap.String("uri", r.RequestURI), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
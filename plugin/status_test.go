@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getStatusAPI(t *testing.T, url string, secret string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	if secret != "" {
+		assert.NoError(t, signRequest(req, []byte{}, secret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestStatus_RequiresSignature(t *testing.T) {
+	const secret = "secret"
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{})
+	dockerLoader.options.Secret = secret
+	version := setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+
+	server := httptest.NewServer(createStatusHandler(dockerLoader))
+	defer server.Close()
+
+	for _, path := range []string{"/status", "/history"} {
+		assert.Equal(t, http.StatusUnauthorized, getStatusAPI(t, server.URL+path, "").StatusCode, path)
+		assert.Equal(t, http.StatusUnauthorized, getStatusAPI(t, server.URL+path, "other secret").StatusCode, path)
+		assert.Equal(t, http.StatusOK, getStatusAPI(t, server.URL+path, secret).StatusCode, path)
+	}
+
+	status := &Status{}
+	assert.NoError(t, json.NewDecoder(getStatusAPI(t, server.URL+"/status", secret).Body).Decode(status))
+	assert.Equal(t, version, status.Version)
+	assert.JSONEq(t, `{"apps":{}}`, string(status.Config))
+
+	// Metrics don't include configurations
+	assert.Equal(t, http.StatusOK, getStatusAPI(t, server.URL+"/metrics", "").StatusCode)
+}
+
+func TestStatus_WithoutSecret(t *testing.T) {
+	dockerLoader := createTestLoader(t, map[string]*fakeCaddy{})
+	setTestVersion(t, dockerLoader, []byte(`{"apps":{}}`), "10.0.0.1")
+
+	server := httptest.NewServer(createStatusHandler(dockerLoader))
+	defer server.Close()
+
+	assert.Equal(t, http.StatusOK, getStatusAPI(t, server.URL+"/status", "").StatusCode)
+	assert.Equal(t, http.StatusOK, getStatusAPI(t, server.URL+"/history", "").StatusCode)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/rollback", nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
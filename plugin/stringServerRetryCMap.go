@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// ServerRetry is the retry state of a server that failed to be configured
+type ServerRetry struct {
+	Attempts int
+	Timer    *time.Timer
+}
+
+// StringServerRetryCMap is a concurrent map implementation of map[string]*ServerRetry
+type StringServerRetryCMap struct {
+	mutex    sync.RWMutex
+	internal map[string]*ServerRetry
+}
+
+func newStringServerRetryCMap() *StringServerRetryCMap {
+	return &StringServerRetryCMap{
+		mutex:    sync.RWMutex{},
+		internal: map[string]*ServerRetry{},
+	}
+}
+
+// Set map value
+func (m *StringServerRetryCMap) Set(key string, value *ServerRetry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.internal[key] = value
+}
+
+// Get map value or default
+func (m *StringServerRetryCMap) Get(key string) *ServerRetry {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.internal[key]
+}
+
+// Delete map value
+func (m *StringServerRetryCMap) Delete(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.internal, key)
+}
+
+// Keys returns all map keys
+func (m *StringServerRetryCMap) Keys() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := make([]string, 0, len(m.internal))
+	for key := range m.internal {
+		keys = append(keys, key)
+	}
+	return keys
+}
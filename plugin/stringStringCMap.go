@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"sync"
+)
+
+// StringStringCMap is a concurrent map implementation of map[string]string
+type StringStringCMap struct {
+	mutex    sync.RWMutex
+	internal map[string]string
+}
+
+func newStringStringCMap() *StringStringCMap {
+	return &StringStringCMap{
+		mutex:    sync.RWMutex{},
+		internal: map[string]string{},
+	}
+}
+
+// Set map value
+func (m *StringStringCMap) Set(key string, value string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.internal[key] = value
+}
+
+// Get map value or default
+func (m *StringStringCMap) Get(key string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.internal[key]
+}
+
+// Delete map value
+func (m *StringStringCMap) Delete(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.internal, key)
+}
+
+// ToMap returns a copy of map values
+func (m *StringStringCMap) ToMap() map[string]string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	values := make(map[string]string, len(m.internal))
+	for key, value := range m.internal {
+		values[key] = value
+	}
+	return values
+}